@@ -9,4 +9,5 @@ require (
 	github.com/grafana/simple-datasource-backend v0.0.0-20201006094704-cab03d64bfb1 // indirect
 	github.com/ibmdb/go_ibm_db v0.3.0
 	github.com/magefile/mage v1.10.0
+	github.com/prometheus/client_golang v1.3.0
 )
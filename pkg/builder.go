@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// queryBuilder is the structured (no-SQL) form of a query, as set up in the query editor.
+type queryBuilder struct {
+	Schema     string             `json:"schema"`
+	Table      string             `json:"table"`
+	Columns    []string           `json:"columns"`
+	Aggregates []builderAggregate `json:"aggregates"`
+	TimeColumn string             `json:"timeColumn"` // Filtered on the time range of the panel.
+	GroupBy    []string           `json:"groupBy"`
+	OrderBy    []builderOrder     `json:"orderBy"`
+	Limit      int64              `json:"limit"`
+}
+
+// builderAggregate is an aggregated column of a structured query, e.g. SUM(AMOUNT) AS TOTAL.
+type builderAggregate struct {
+	Function string `json:"function"`
+	Column   string `json:"column"` // * counts the rows, only with COUNT.
+	Alias    string `json:"alias"`
+}
+
+// aggregateFunctions are the aggregate functions a structured query can use.
+var aggregateFunctions = map[string]bool{"COUNT": true, "SUM": true, "AVG": true, "MIN": true, "MAX": true}
+
+// builderOrder is a single sort key of a structured query.
+type builderOrder struct {
+	Column    string `json:"column"`
+	Direction string `json:"direction"`
+}
+
+// identifierPattern matches ordinary (unquoted) Db2 identifiers.
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_#@$][A-Za-z0-9_#@$]*$`)
+
+func validIdentifier(name string) error {
+	if !identifierPattern.MatchString(name) {
+		return fmt.Errorf("invalid identifier %q", name)
+	}
+	return nil
+}
+
+// sql generates the SELECT statement for the structured query. Identifiers are
+// validated instead of quoted, so Db2 folds them to upper case like it would in
+// hand-written SQL.
+func (b *queryBuilder) sql() (string, error) {
+	if err := validIdentifier(b.Table); err != nil {
+		return "", err
+	}
+
+	table := b.Table
+	if b.Schema != "" {
+		if err := validIdentifier(b.Schema); err != nil {
+			return "", err
+		}
+		table = b.Schema + "." + b.Table
+	}
+
+	var selected []string
+	for _, c := range b.Columns {
+		if err := validIdentifier(c); err != nil {
+			return "", err
+		}
+		selected = append(selected, c)
+	}
+	for _, a := range b.Aggregates {
+		aggregate, err := a.sql()
+		if err != nil {
+			return "", err
+		}
+		selected = append(selected, aggregate)
+	}
+
+	columns := "*"
+	if len(selected) > 0 {
+		columns = strings.Join(selected, ", ")
+	}
+
+	sql := fmt.Sprintf("SELECT %s FROM %s", columns, table)
+
+	//The macro is expanded with the others, for the time range of the query.
+	if b.TimeColumn != "" {
+		if err := validIdentifier(b.TimeColumn); err != nil {
+			return "", err
+		}
+		sql += fmt.Sprintf(" WHERE $__timeFilter(%s)", b.TimeColumn)
+	}
+
+	if len(b.GroupBy) > 0 {
+		for _, c := range b.GroupBy {
+			if err := validIdentifier(c); err != nil {
+				return "", err
+			}
+		}
+		sql += " GROUP BY " + strings.Join(b.GroupBy, ", ")
+	}
+
+	if len(b.OrderBy) > 0 {
+		keys := make([]string, len(b.OrderBy))
+		for i, o := range b.OrderBy {
+			if err := validIdentifier(o.Column); err != nil {
+				return "", err
+			}
+
+			direction := strings.ToUpper(o.Direction)
+			switch direction {
+			case "":
+				direction = "ASC"
+			case "ASC", "DESC":
+			default:
+				return "", fmt.Errorf("invalid sort direction %q", o.Direction)
+			}
+
+			keys[i] = o.Column + " " + direction
+		}
+		sql += " ORDER BY " + strings.Join(keys, ", ")
+	}
+
+	if b.Limit < 0 {
+		return "", fmt.Errorf("invalid row limit %d", b.Limit)
+	}
+	if b.Limit > 0 {
+		sql += fmt.Sprintf(" FETCH FIRST %d ROWS ONLY", b.Limit)
+	}
+
+	return sql, nil
+}
+
+// sql returns the aggregate as a select list item.
+func (a builderAggregate) sql() (string, error) {
+	function := strings.ToUpper(a.Function)
+	if !aggregateFunctions[function] {
+		return "", fmt.Errorf("invalid aggregate function %q", a.Function)
+	}
+
+	if a.Column != "*" || function != "COUNT" {
+		if err := validIdentifier(a.Column); err != nil {
+			return "", err
+		}
+	}
+	aggregate := fmt.Sprintf("%s(%s)", function, a.Column)
+
+	if a.Alias != "" {
+		if err := validIdentifier(a.Alias); err != nil {
+			return "", err
+		}
+		aggregate += " AS " + a.Alias
+	}
+
+	return aggregate, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func TestQueryBuilderSQL(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder queryBuilder
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "all columns",
+			builder: queryBuilder{Table: "SALES"},
+			want:    "SELECT * FROM SALES",
+		},
+		{
+			name:    "columns of a schema's table",
+			builder: queryBuilder{Schema: "APP", Table: "SALES", Columns: []string{"SOLD_AT", "AMOUNT"}},
+			want:    "SELECT SOLD_AT, AMOUNT FROM APP.SALES",
+		},
+		{
+			name:    "time filter",
+			builder: queryBuilder{Table: "SALES", Columns: []string{"SOLD_AT", "AMOUNT"}, TimeColumn: "SOLD_AT"},
+			want:    "SELECT SOLD_AT, AMOUNT FROM SALES WHERE $__timeFilter(SOLD_AT)",
+		},
+		{
+			name: "aggregated per group",
+			builder: queryBuilder{
+				Table:      "SALES",
+				Columns:    []string{"REGION"},
+				Aggregates: []builderAggregate{{Function: "sum", Column: "AMOUNT", Alias: "TOTAL"}, {Function: "COUNT", Column: "*"}},
+				TimeColumn: "SOLD_AT",
+				GroupBy:    []string{"REGION"},
+			},
+			want: "SELECT REGION, SUM(AMOUNT) AS TOTAL, COUNT(*) FROM SALES WHERE $__timeFilter(SOLD_AT) GROUP BY REGION",
+		},
+		{
+			name:    "order",
+			builder: queryBuilder{Table: "SALES", OrderBy: []builderOrder{{Column: "SOLD_AT", Direction: "desc"}, {Column: "ID"}}},
+			want:    "SELECT * FROM SALES ORDER BY SOLD_AT DESC, ID ASC",
+		},
+		{
+			name:    "limit",
+			builder: queryBuilder{Table: "SALES", Limit: 100},
+			want:    "SELECT * FROM SALES FETCH FIRST 100 ROWS ONLY",
+		},
+		{
+			name: "every clause",
+			builder: queryBuilder{
+				Schema:     "APP",
+				Table:      "SALES",
+				Columns:    []string{"REGION"},
+				Aggregates: []builderAggregate{{Function: "MAX", Column: "AMOUNT"}},
+				TimeColumn: "SOLD_AT",
+				GroupBy:    []string{"REGION"},
+				OrderBy:    []builderOrder{{Column: "REGION"}},
+				Limit:      10,
+			},
+			want: "SELECT REGION, MAX(AMOUNT) FROM APP.SALES WHERE $__timeFilter(SOLD_AT) GROUP BY REGION ORDER BY REGION ASC FETCH FIRST 10 ROWS ONLY",
+		},
+		{name: "invalid table", builder: queryBuilder{Table: "SALES; DROP TABLE SALES"}, wantErr: true},
+		{name: "invalid column", builder: queryBuilder{Table: "SALES", Columns: []string{"1=1"}}, wantErr: true},
+		{name: "invalid time column", builder: queryBuilder{Table: "SALES", TimeColumn: "SOLD_AT)"}, wantErr: true},
+		{name: "invalid group column", builder: queryBuilder{Table: "SALES", GroupBy: []string{"REGION, 1"}}, wantErr: true},
+		{name: "invalid aggregate function", builder: queryBuilder{Table: "SALES", Aggregates: []builderAggregate{{Function: "STDDEV_SAMP", Column: "AMOUNT"}}}, wantErr: true},
+		{name: "star outside of count", builder: queryBuilder{Table: "SALES", Aggregates: []builderAggregate{{Function: "SUM", Column: "*"}}}, wantErr: true},
+		{name: "invalid alias", builder: queryBuilder{Table: "SALES", Aggregates: []builderAggregate{{Function: "SUM", Column: "AMOUNT", Alias: "TOTAL AMOUNT"}}}, wantErr: true},
+		{name: "invalid direction", builder: queryBuilder{Table: "SALES", OrderBy: []builderOrder{{Column: "ID", Direction: "UP"}}}, wantErr: true},
+		{name: "negative limit", builder: queryBuilder{Table: "SALES", Limit: -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.builder.sql()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sql() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("sql() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type ttlCacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// ttlCache holds values for a fixed time after they were stored. It backs the caches of the
+// query editor's lookups, which it repeats on every edit while their answers rarely change.
+type ttlCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[string]ttlCacheEntry
+}
+
+func newTTLCache(ttl time.Duration) *ttlCache {
+	return &ttlCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[string]ttlCacheEntry{},
+	}
+}
+
+func (c *ttlCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || c.now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// put stores a value, and drops the expired entries.
+func (c *ttlCache) put(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = ttlCacheEntry{value: value, expires: now.Add(c.ttl)}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLCache(t *testing.T) {
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		put     bool
+		elapsed time.Duration
+		wantOK  bool
+	}{
+		{name: "missing"},
+		{name: "fresh", put: true, wantOK: true},
+		{name: "at the ttl", put: true, elapsed: time.Minute, wantOK: true},
+		{name: "expired", put: true, elapsed: time.Minute + time.Nanosecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := start
+			c := newTTLCache(time.Minute)
+			c.now = func() time.Time { return now }
+
+			if tt.put {
+				c.put("SELECT 1", []resultColumn{{Name: "ONE", Type: "INTEGER"}})
+			}
+			now = now.Add(tt.elapsed)
+
+			got, ok := c.get("SELECT 1")
+			if ok != tt.wantOK {
+				t.Fatalf("get() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && got.([]resultColumn)[0].Name != "ONE" {
+				t.Errorf("get() = %v", got)
+			}
+		})
+	}
+}
+
+func TestTTLCacheDropsExpiredEntries(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := newTTLCache(time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.put("schemas", []string{"APP"})
+	now = now.Add(2 * time.Minute)
+	c.put("tables", []string{"ORDERS"})
+
+	if len(c.entries) != 1 {
+		t.Errorf("entries = %d, want 1", len(c.entries))
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// clientInfo holds the client attributes Db2 Workload Manager can classify a query on.
+type clientInfo struct {
+	userID   string // CURRENT CLIENT_USERID
+	applName string // CURRENT CLIENT_APPLNAME, carries the workload class.
+}
+
+func (ci clientInfo) empty() bool {
+	return ci.userID == "" && ci.applName == ""
+}
+
+// set applies the client attributes to the connection.
+func (ci clientInfo) set(ctx context.Context, conn *sql.Conn) error {
+	return setClientInfo(ctx, conn, nullIfEmpty(ci.userID), nullIfEmpty(ci.applName))
+}
+
+// reset restores the client attributes that set applied to their defaults, before the
+// connection goes back to the pool.
+func (ci clientInfo) reset(conn *sql.Conn) error {
+	var userID, applName interface{}
+	if ci.userID != "" {
+		userID = ""
+	}
+	if ci.applName != "" {
+		applName = ""
+	}
+	return setClientInfo(context.Background(), conn, userID, applName)
+}
+
+// setClientInfo calls WLM_SET_CLIENT_INFO, which leaves attributes passed as NULL untouched
+// and resets attributes passed as an empty string.
+func setClientInfo(ctx context.Context, conn *sql.Conn, userID, applName interface{}) error {
+	_, err := conn.ExecContext(ctx, "CALL SYSPROC.WLM_SET_CLIENT_INFO(?, NULL, ?, NULL, NULL)", userID, applName)
+	return err
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// queryWithClientInfo runs the query on a dedicated connection that has the client attributes set.
+// The returned release func resets the attributes and returns the connection to the pool, it must
+// be called after the rows are closed.
+func queryWithClientInfo(ctx context.Context, db *sql.DB, info clientInfo, queryText string, args []interface{}) (*sql.Rows, func(), error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, func() {}, err
+	}
+
+	release := func() {
+		if err := info.reset(conn); err != nil {
+			log.DefaultLogger.Warn("Failed resetting client info", "err", err)
+		}
+		conn.Close()
+	}
+
+	err = info.set(ctx, conn)
+	if err != nil {
+		conn.Close()
+		return nil, func() {}, err
+	}
+
+	rows, err := conn.QueryContext(ctx, queryText, args...)
+	if err != nil {
+		release()
+		return nil, func() {}, err
+	}
+
+	return rows, release, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+)
+
+func TestQueryWithClientInfo(t *testing.T) {
+	const call = "CALL SYSPROC.WLM_SET_CLIENT_INFO(?, NULL, ?, NULL, NULL)"
+
+	tests := []struct {
+		name      string
+		info      clientInfo
+		wantSet   []driver.Value
+		wantReset []driver.Value
+	}{
+		{
+			name:      "user and workload class",
+			info:      clientInfo{userID: "alice", applName: "REPORTS"},
+			wantSet:   []driver.Value{"alice", "REPORTS"},
+			wantReset: []driver.Value{"", ""},
+		},
+		{
+			name:      "workload class only",
+			info:      clientInfo{applName: "REPORTS"},
+			wantSet:   []driver.Value{nil, "REPORTS"},
+			wantReset: []driver.Value{nil, ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &fakeResult{}
+			rows, release, err := queryWithClientInfo(context.Background(), openFake(t, result), tt.info, "SELECT 1", nil)
+			if err != nil {
+				t.Fatalf("queryWithClientInfo() error = %v", err)
+			}
+			rows.Close()
+			release()
+
+			if want := []string{call, "SELECT 1", call}; !reflect.DeepEqual(result.statements, want) {
+				t.Errorf("statements = %q, want %q", result.statements, want)
+			}
+			if want := [][]driver.Value{tt.wantSet, tt.wantReset}; !reflect.DeepEqual(result.execArgs, want) {
+				t.Errorf("client info args = %v, want %v", result.execArgs, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,776 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// scanOptions are the per-query options that change how column values are scanned.
+type scanOptions struct {
+	trimChar          bool
+	emptyStringAsNull bool
+	timeColumn        string
+	timeColumnType    string // Unit of an integer time column, a timestamp column when empty.
+	timeOfDayColumn   string
+	skipBadTimeRows   bool
+	partialOnError    bool
+	maxRows           int64 // Rows read at most, 0 reads all.
+	boolColumns       []string
+	truthyValues      []string
+	decimalAsString   bool
+	maxTextLength     int    // Characters of CLOB and bytes of binary values kept, 0 uses the default.
+	binaryEncoding    string // Encoding of binary values, base64 when empty.
+	rowCapacity       int    // Expected number of rows, the fields reserve room for them.
+	noTimeColumn      bool   // For tables, the first column is an ordinary column unless a time column is named.
+}
+
+// defaultTruthyValues are the flag values that are read as true in boolean columns, "1"
+// for SMALLINT flags.
+var defaultTruthyValues = []string{"Y", "T", "1"}
+
+// columnScanner receives the values of a single result column and collects them in a field.
+// A scanner without a field only receives values, for another scanner to combine into its field.
+type columnScanner struct {
+	field *data.Field
+	dest  interface{}        // Pointer handed to rows.Scan.
+	value func() interface{} // Returns the scanned value, typed for the field.
+	check func() error       // Optional, converts the scanned value and reports when it can't.
+
+	//Optional, for scanners that only know their field's type after all rows are scanned.
+	collect func()             // Keeps the scanned value.
+	finish  func() *data.Field // Builds the field from the kept values.
+
+	truncated int // Values cut off at the text length cap.
+}
+
+func (c *columnScanner) append() {
+	switch {
+	case c.collect != nil:
+		c.collect()
+	case c.field != nil:
+		c.field.Append(c.value())
+	}
+}
+
+// maxRowCapacity caps the rows reserved up front, a high row limit doesn't mean the result is big.
+const maxRowCapacity = 10000
+
+// reserve grows the capacity of an empty field to n values, so appending them doesn't reallocate.
+// The SDK has no way to create a field with spare capacity, so it's extended and emptied again.
+func reserve(field *data.Field, n int) {
+	if n > maxRowCapacity {
+		n = maxRowCapacity
+	}
+	field.Extend(n)
+	for i := n - 1; i >= 0; i-- {
+		field.Delete(i)
+	}
+}
+
+// columnIndex returns the index of the named column, or -1 when the result doesn't have it.
+// Unquoted Db2 names are upper case, so the name is matched case-insensitively.
+func columnIndex(colTypes []*sql.ColumnType, name string) int {
+	for i, colType := range colTypes {
+		if strings.EqualFold(colType.Name(), name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// newTimeScanner returns a scanner for the time column. A lenient scanner receives the raw
+// value and converts it itself, so a row with an unparseable time can be skipped.
+func newTimeScanner(name string, lenient bool) *columnScanner {
+	var t time.Time
+
+	if lenient {
+		var raw interface{}
+		return &columnScanner{
+			field: data.NewField(name, nil, []time.Time{}),
+			dest:  &raw,
+			value: func() interface{} { return t },
+			check: func() (err error) {
+				t, err = parseTime(raw)
+				return err
+			},
+		}
+	}
+
+	return &columnScanner{
+		field: data.NewField(name, nil, []time.Time{}),
+		dest:  &t,
+		value: func() interface{} { return asUTC(t) },
+	}
+}
+
+// Units of integer time columns that can be set per query.
+const (
+	timeColumnTypeEpochS  = "epoch_s"
+	timeColumnTypeEpochMs = "epoch_ms"
+)
+
+func validTimeColumnType(t string) error {
+	switch t {
+	case "", timeColumnTypeEpochS, timeColumnTypeEpochMs:
+		return nil
+	}
+	return fmt.Errorf("unknown time column type %q", t)
+}
+
+// newEpochTimeScanner returns a scanner for a time column holding seconds or milliseconds
+// since the Unix epoch. Like newTimeScanner, a lenient scanner lets a row with a null time
+// be skipped.
+func newEpochTimeScanner(name, unit string, lenient bool) *columnScanner {
+	scale := time.Second
+	if unit == timeColumnTypeEpochMs {
+		scale = time.Millisecond
+	}
+	toTime := func(epoch int64) time.Time {
+		return time.Unix(0, epoch*int64(scale)).UTC()
+	}
+
+	if lenient {
+		var i sql.NullInt64
+		var t time.Time
+		return &columnScanner{
+			field: data.NewField(name, nil, []time.Time{}),
+			dest:  &i,
+			value: func() interface{} { return t },
+			check: func() error {
+				if !i.Valid {
+					return fmt.Errorf("time value is null")
+				}
+				t = toTime(i.Int64)
+				return nil
+			},
+		}
+	}
+
+	var i int64
+	return &columnScanner{
+		field: data.NewField(name, nil, []time.Time{}),
+		dest:  &i,
+		value: func() interface{} { return toTime(i) },
+	}
+}
+
+// timeLayouts are the textual timestamp formats parseTime accepts, Db2's own first.
+var timeLayouts = []string{
+	"2006-01-02-15.04.05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02",
+	"15.04.05",
+	"15:04:05",
+}
+
+// asUTC returns the wall clock of a driver time in UTC. go_ibm_db builds TIMESTAMP, DATE and
+// TIME values in the local zone of the Grafana server, while the macros write the time range
+// as UTC timestamps. Db2 timestamps are taken as UTC either way, wherever Grafana runs.
+func asUTC(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// parseTime converts a raw time value, as returned by the driver, into a UTC time.
+func parseTime(raw interface{}) (time.Time, error) {
+	var s string
+	switch v := raw.(type) {
+	case time.Time:
+		return asUTC(v), nil
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case nil:
+		return time.Time{}, fmt.Errorf("time value is null")
+	default:
+		return time.Time{}, fmt.Errorf("unsupported time value of type %T", raw)
+	}
+
+	s = strings.TrimSpace(s)
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			//A time of day parses into year 0, the driver's TIME values fall on year 1.
+			if t.Year() == 0 {
+				t = t.AddDate(1, 0, 0)
+			}
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unparseable time value %q", s)
+}
+
+// newCompositeTimeScanners returns the scanners for a DATE and a TIME column that are combined
+// into a single nullable time field, named after the date column. A missing date gives a null
+// timestamp, a missing time of day gives midnight.
+func newCompositeTimeScanners(dateName, timeOfDayName string) (date, timeOfDay *columnScanner) {
+	var d, t sql.NullTime
+
+	date = &columnScanner{
+		field: data.NewField(dateName, nil, []*time.Time{}),
+		dest:  &d,
+		value: func() interface{} {
+			if !d.Valid {
+				return (*time.Time)(nil)
+			}
+			ts := time.Date(d.Time.Year(), d.Time.Month(), d.Time.Day(), 0, 0, 0, 0, time.UTC)
+			if t.Valid {
+				ts = ts.Add(time.Duration(t.Time.Hour())*time.Hour +
+					time.Duration(t.Time.Minute())*time.Minute +
+					time.Duration(t.Time.Second())*time.Second +
+					time.Duration(t.Time.Nanosecond()))
+			}
+			return &ts
+		},
+	}
+
+	timeOfDay = &columnScanner{
+		dest: &t,
+	}
+
+	return date, timeOfDay
+}
+
+// newColumnScanner returns a scanner for a value column, based on its Db2 type. The fields
+// are nullable, a NULL becomes a gap instead of failing the scan.
+func newColumnScanner(colType *sql.ColumnType, opts scanOptions) *columnScanner {
+	name := colType.Name()
+
+	for _, boolColumn := range opts.boolColumns {
+		if strings.EqualFold(boolColumn, name) {
+			return newFlagScanner(name, opts.truthyValues)
+		}
+	}
+
+	//The driver fetches XML as SQL_C_BINARY too, its bytes are the serialized document.
+	if strings.EqualFold(colType.DatabaseTypeName(), "XML") {
+		return newTextScanner(name, opts)
+	}
+
+	//CLI names FOR BIT DATA columns e.g. "CHAR () FOR BIT DATA", the driver fetches them as
+	//SQL_C_BINARY like BLOB, BINARY and VARBINARY, which it reports as a []byte scan type.
+	if colType.ScanType() == reflect.TypeOf([]byte(nil)) {
+		return newBinaryScanner(name, opts)
+	}
+
+	switch strings.ToUpper(colType.DatabaseTypeName()) {
+	case "CHAR":
+		//Fixed width columns come back padded with spaces.
+		return newStringScanner(name, opts, opts.trimChar)
+	case "VARCHAR", "LONG VARCHAR":
+		return newStringScanner(name, opts, false)
+	case "GRAPHIC":
+		return newGraphicScanner(name, opts, opts.trimChar)
+	case "VARGRAPHIC", "LONG VARGRAPHIC":
+		return newGraphicScanner(name, opts, false)
+	case "CLOB", "DBCLOB":
+		return newTextScanner(name, opts)
+	case "BLOB", "BINARY", "VARBINARY":
+		return newBinaryScanner(name, opts)
+	case "DECIMAL", "NUMERIC", "DEC":
+		//go_ibm_db fetches DECIMAL as a double, as text it's only written without an exponent.
+		//Digits past the double's precision are gone either way, see lossyDecimal.
+		if opts.decimalAsString {
+			_, scale, _ := colType.DecimalSize()
+			return newDecimalStringScanner(name, int(scale))
+		}
+		return newFloatScanner(name)
+	case "DECFLOAT":
+		//go_ibm_db fetches DECFLOAT as text, which keeps all the digits and the range of a DECFLOAT(34).
+		if opts.decimalAsString {
+			return newStringScanner(name, opts, false)
+		}
+		return newFloatScanner(name)
+	case "DOUBLE", "REAL", "FLOAT":
+		return newFloatScanner(name)
+	case "BOOLEAN":
+		var b sql.NullBool
+		return &columnScanner{
+			field: data.NewField(name, nil, []*bool{}),
+			dest:  &b,
+			value: func() interface{} {
+				if !b.Valid {
+					return (*bool)(nil)
+				}
+				v := b.Bool
+				return &v
+			},
+		}
+	case "TIMESTAMP", "DATE", "TIME":
+		return newTimeValueScanner(name)
+	case "SMALLINT":
+		return newIntScanner(name, 16)
+	case "INTEGER", "INT":
+		return newIntScanner(name, 32)
+	case "BIGINT":
+		return newIntScanner(name, 64)
+	default:
+		//Types without a case above, e.g. DATALINK, are typed after their values.
+		return newInferringScanner(name)
+	}
+}
+
+// newIntScanner returns a scanner for an integer column, into a nullable field as wide as
+// the Db2 type: 16 bits for SMALLINT, 32 for INTEGER and 64 for BIGINT.
+func newIntScanner(name string, bits int) *columnScanner {
+	var i sql.NullInt64
+
+	var field *data.Field
+	var value func() interface{}
+	switch bits {
+	case 16:
+		field = data.NewField(name, nil, []*int16{})
+		value = func() interface{} {
+			if !i.Valid {
+				return (*int16)(nil)
+			}
+			v := int16(i.Int64)
+			return &v
+		}
+	case 32:
+		field = data.NewField(name, nil, []*int32{})
+		value = func() interface{} {
+			if !i.Valid {
+				return (*int32)(nil)
+			}
+			v := int32(i.Int64)
+			return &v
+		}
+	default:
+		field = data.NewField(name, nil, []*int64{})
+		value = func() interface{} {
+			if !i.Valid {
+				return (*int64)(nil)
+			}
+			v := i.Int64
+			return &v
+		}
+	}
+
+	return &columnScanner{
+		field: field,
+		dest:  &i,
+		value: value,
+	}
+}
+
+// newFloatScanner returns a scanner for a floating point or decimal column, into a nullable field.
+func newFloatScanner(name string) *columnScanner {
+	var f sql.NullFloat64
+	return &columnScanner{
+		field: data.NewField(name, nil, []*float64{}),
+		dest:  &f,
+		value: func() interface{} {
+			if !f.Valid {
+				return (*float64)(nil)
+			}
+			v := f.Float64
+			return &v
+		},
+	}
+}
+
+// maxExactDecimalDigits is the number of decimal digits a double holds exactly.
+const maxExactDecimalDigits = 15
+
+// lossyDecimal returns whether the column is a DECIMAL with more digits than a double holds.
+// go_ibm_db fetches DECIMAL columns as doubles, so the extra digits are lost before they're
+// scanned, unless the query casts the column to CHAR.
+func lossyDecimal(colType *sql.ColumnType) bool {
+	switch strings.ToUpper(colType.DatabaseTypeName()) {
+	case "DECIMAL", "NUMERIC", "DEC":
+		precision, _, _ := colType.DecimalSize()
+		return precision > maxExactDecimalDigits
+	}
+	return false
+}
+
+// newDecimalStringScanner returns a scanner for a DECIMAL column, into a nullable string
+// field. The value is written with the column's scale and without an exponent, as Db2 would,
+// e.g. 1234567.89 rather than 1.23456789e+06.
+func newDecimalStringScanner(name string, scale int) *columnScanner {
+	//A scale of 0 can also mean the driver didn't report it, then as many places as needed are written.
+	places := scale
+	if places <= 0 {
+		places = -1
+	}
+
+	var f sql.NullFloat64
+	return &columnScanner{
+		field: data.NewField(name, nil, []*string{}),
+		dest:  &f,
+		value: func() interface{} {
+			if !f.Valid {
+				return (*string)(nil)
+			}
+			v := strconv.FormatFloat(f.Float64, 'f', places, 64)
+			return &v
+		},
+	}
+}
+
+// newTimeValueScanner returns a scanner for a TIMESTAMP, DATE or TIME column that isn't
+// the time column, into a nullable time field. A TIME value falls on January 1st of year 1.
+func newTimeValueScanner(name string) *columnScanner {
+	var raw interface{}
+	var t *time.Time
+
+	return &columnScanner{
+		field: data.NewField(name, nil, []*time.Time{}),
+		dest:  &raw,
+		value: func() interface{} { return t },
+		check: func() error {
+			t = nil
+			if raw == nil {
+				return nil
+			}
+			parsed, err := parseTime(raw)
+			if err != nil {
+				return err
+			}
+			t = &parsed
+			return nil
+		},
+	}
+}
+
+// hasColumnType returns whether the driver reported the Db2 type of the column. Some driver
+// and Db2 combinations leave it empty.
+func hasColumnType(colType *sql.ColumnType) bool {
+	name := strings.ToUpper(colType.DatabaseTypeName())
+	return name != "" && name != "UNKNOWN"
+}
+
+// newInferringScanner returns a scanner for a column without a reported type. It keeps
+// the raw values and types the field after the Go type of the first non-null value.
+func newInferringScanner(name string) *columnScanner {
+	var raw interface{}
+	var values []interface{}
+
+	return &columnScanner{
+		dest:    &raw,
+		collect: func() { values = append(values, raw) },
+		finish:  func() *data.Field { return inferField(name, values) },
+	}
+}
+
+// inferField builds a nullable field from raw driver values. Values that don't match the
+// type of the first non-null value turn the whole field into strings.
+func inferField(name string, values []interface{}) *data.Field {
+	var sample interface{}
+	for _, v := range values {
+		if v != nil {
+			sample = v
+			break
+		}
+	}
+
+	var convert func(v interface{}) (interface{}, bool)
+	var fieldType data.FieldType
+
+	switch sample.(type) {
+	case int, int8, int16, int32, int64, uint8, uint16, uint32:
+		fieldType = data.FieldTypeNullableInt64
+		convert = func(v interface{}) (interface{}, bool) {
+			i, ok := toInt64(v)
+			return &i, ok
+		}
+	case float32, float64:
+		fieldType = data.FieldTypeNullableFloat64
+		convert = func(v interface{}) (interface{}, bool) {
+			switch f := v.(type) {
+			case float32:
+				f64 := float64(f)
+				return &f64, true
+			case float64:
+				return &f, true
+			}
+			return nil, false
+		}
+	case bool:
+		fieldType = data.FieldTypeNullableBool
+		convert = func(v interface{}) (interface{}, bool) {
+			b, ok := v.(bool)
+			return &b, ok
+		}
+	case time.Time:
+		fieldType = data.FieldTypeNullableTime
+		convert = func(v interface{}) (interface{}, bool) {
+			t, ok := v.(time.Time)
+			t = asUTC(t)
+			return &t, ok
+		}
+	}
+
+	if convert != nil {
+		field := data.NewFieldFromFieldType(fieldType, len(values))
+		field.Name = name
+		matched := true
+		for i, v := range values {
+			if v == nil {
+				continue
+			}
+			converted, ok := convert(v)
+			if !ok {
+				matched = false
+				break
+			}
+			field.Set(i, converted)
+		}
+		if matched {
+			return field
+		}
+	}
+
+	//Strings, byte slices, all nulls and mixed types.
+	strs := make([]*string, len(values))
+	for i, v := range values {
+		var s string
+		switch t := v.(type) {
+		case nil:
+			continue
+		case []byte:
+			s = string(t)
+		default:
+			s = fmt.Sprint(t)
+		}
+		strs[i] = &s
+	}
+	return data.NewField(name, nil, strs)
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch i := v.(type) {
+	case int:
+		return int64(i), true
+	case int8:
+		return int64(i), true
+	case int16:
+		return int64(i), true
+	case int32:
+		return int64(i), true
+	case int64:
+		return i, true
+	case uint8:
+		return int64(i), true
+	case uint16:
+		return int64(i), true
+	case uint32:
+		return int64(i), true
+	}
+	return 0, false
+}
+
+// newStringScanner returns a scanner for a character column, into a nullable field. With
+// emptyStringAsNull set, empty strings are stored as null too.
+func newStringScanner(name string, opts scanOptions, trim bool) *columnScanner {
+	var s sql.NullString
+	return &columnScanner{
+		field: data.NewField(name, nil, []*string{}),
+		dest:  &s,
+		value: func() interface{} {
+			v := s.String
+			if trim {
+				v = strings.TrimRight(v, " ")
+			}
+			if !s.Valid || (opts.emptyStringAsNull && v == "") {
+				return (*string)(nil)
+			}
+			return &v
+		},
+	}
+}
+
+// defaultMaxTextLength is the number of characters kept of a CLOB value when the query doesn't set a cap.
+const defaultMaxTextLength = 64 * 1024
+
+// newTextScanner returns a scanner for a CLOB column, into a nullable string field. Values
+// longer than the cap are cut off, so a column of large documents only yields a preview.
+func newTextScanner(name string, opts scanOptions) *columnScanner {
+	maxLength := opts.maxTextLength
+	if maxLength <= 0 {
+		maxLength = defaultMaxTextLength
+	}
+
+	var s sql.NullString
+	scanner := &columnScanner{
+		field: data.NewField(name, nil, []*string{}),
+		dest:  &s,
+	}
+	scanner.value = func() interface{} {
+		if !s.Valid || (opts.emptyStringAsNull && s.String == "") {
+			return (*string)(nil)
+		}
+		v, cut := truncateText(s.String, maxLength)
+		if cut {
+			scanner.truncated++
+		}
+		return &v
+	}
+
+	return scanner
+}
+
+// truncateText returns the first maxLength characters of s, and whether any were cut off.
+func truncateText(s string, maxLength int) (string, bool) {
+	if len(s) <= maxLength {
+		return s, false
+	}
+
+	n := 0
+	for i := range s {
+		if n == maxLength {
+			return s[:i], true
+		}
+		n++
+	}
+	return s, false
+}
+
+// Encodings of binary values that can be set per query.
+const (
+	binaryEncodingBase64 = "base64"
+	binaryEncodingHex    = "hex"
+)
+
+func validBinaryEncoding(e string) error {
+	switch e {
+	case "", binaryEncodingBase64, binaryEncodingHex:
+		return nil
+	}
+	return fmt.Errorf("unknown binary encoding %q", e)
+}
+
+// newBinaryScanner returns a scanner for a BLOB or other binary column, into a nullable
+// string field holding the encoded bytes, base64 unless hex is asked for. Values longer
+// than the text length cap are cut off before they're encoded.
+func newBinaryScanner(name string, opts scanOptions) *columnScanner {
+	maxLength := opts.maxTextLength
+	if maxLength <= 0 {
+		maxLength = defaultMaxTextLength
+	}
+
+	var b []byte
+	scanner := &columnScanner{
+		field: data.NewField(name, nil, []*string{}),
+		dest:  &b,
+	}
+	scanner.value = func() interface{} {
+		//database/sql scans a NULL as a nil slice, an empty value as an empty one.
+		if b == nil {
+			return (*string)(nil)
+		}
+		if len(b) > maxLength {
+			b = b[:maxLength]
+			scanner.truncated++
+		}
+
+		var v string
+		if opts.binaryEncoding == binaryEncodingHex {
+			v = hex.EncodeToString(b)
+		} else {
+			v = base64.StdEncoding.EncodeToString(b)
+		}
+		return &v
+	}
+
+	return scanner
+}
+
+// newGraphicScanner returns a scanner for a GRAPHIC, VARGRAPHIC or LONG VARGRAPHIC column,
+// into a nullable string field. go_ibm_db fetches these columns as SQL_C_WCHAR and converts
+// the UTF-16 to UTF-8 itself, so the bytes are read as UTF-8 and never decoded again: the
+// text "-N" has the same bytes as "中" in UTF-16LE. DBCLOB columns are fetched as
+// SQL_C_DBCHAR and read by newTextScanner.
+func newGraphicScanner(name string, opts scanOptions, trim bool) *columnScanner {
+	var s sql.NullString
+	return &columnScanner{
+		field: data.NewField(name, nil, []*string{}),
+		dest:  &s,
+		value: func() interface{} {
+			if !s.Valid {
+				return (*string)(nil)
+			}
+			v := graphicText(s.String, trim)
+			if opts.emptyStringAsNull && v == "" {
+				return (*string)(nil)
+			}
+			return &v
+		},
+	}
+}
+
+// graphicText cleans up a graphic value as the driver returns it. Invalid UTF-8, from an
+// unpaired surrogate in the UTF-16, is replaced rather than garbled by the panel, and the
+// padding of a fixed width column is trimmed when asked.
+func graphicText(s string, trim bool) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	if trim {
+		s = strings.TrimRight(s, " ")
+	}
+	return s
+}
+
+// newFlagScanner returns a scanner that reads a flag column, like a CHAR(1) holding 'Y' or 'N',
+// as a boolean. Values matching one of the truthy values are true, others are false.
+func newFlagScanner(name string, truthyValues []string) *columnScanner {
+	if len(truthyValues) == 0 {
+		truthyValues = defaultTruthyValues
+	}
+
+	var s sql.NullString
+	return &columnScanner{
+		field: data.NewField(name, nil, []*bool{}),
+		dest:  &s,
+		value: func() interface{} {
+			if !s.Valid {
+				return (*bool)(nil)
+			}
+			b := false
+			for _, truthy := range truthyValues {
+				if strings.EqualFold(strings.TrimSpace(s.String), truthy) {
+					b = true
+					break
+				}
+			}
+			return &b
+		},
+	}
+}
+
+// Field name casings that can be set in the datasource settings.
+const (
+	fieldNameCasePreserve = "preserve"
+	fieldNameCaseLower    = "lower"
+	fieldNameCaseUpper    = "upper"
+)
+
+func validFieldNameCase(c string) error {
+	switch c {
+	case "", fieldNameCasePreserve, fieldNameCaseLower, fieldNameCaseUpper:
+		return nil
+	}
+	return fmt.Errorf("unknown field name case %q", c)
+}
+
+// normalizeFieldNames changes the casing of the frame's field names, so fields can be
+// referenced by the same name regardless of how Db2 folded them.
+func normalizeFieldNames(frame *data.Frame, fieldNameCase string) {
+	for _, field := range frame.Fields {
+		switch fieldNameCase {
+		case fieldNameCaseLower:
+			field.Name = strings.ToLower(field.Name)
+		case fieldNameCaseUpper:
+			field.Name = strings.ToUpper(field.Name)
+		}
+	}
+}
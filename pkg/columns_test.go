@@ -0,0 +1,339 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestGraphicScanner(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   interface{} // As the driver returns it, UTF-8 converted from SQL_C_WCHAR.
+		trim  bool
+		want  string
+		isNil bool
+	}{
+		{"ascii", []byte("abc"), false, "abc", false},
+		{"cjk", []byte("中文"), false, "中文", false},
+		//2D 4E is "中" in UTF-16LE, but the driver already decoded the UTF-16, so it's "-N".
+		{"ascii-looking utf-16 pair", []byte{0x2d, 0x4e}, false, "-N", false},
+		{"ascii-looking utf-16 pairs", []byte{0x41, 0x42, 0x43, 0x44}, false, "ABCD", false},
+		{"invalid utf-8", []byte{0x41, 0xff}, false, "A�", false},
+		{"padded", []byte("中  "), true, "中", false},
+		{"padding kept", []byte("中  "), false, "中  ", false},
+		{"null", nil, false, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scanner := newGraphicScanner("G", scanOptions{}, tt.trim)
+			if err := scanner.dest.(*sql.NullString).Scan(tt.raw); err != nil {
+				t.Fatal(err)
+			}
+
+			got := scanner.value().(*string)
+			if tt.isNil {
+				if got != nil {
+					t.Errorf("value() = %q, want null", *got)
+				}
+				return
+			}
+			if got == nil || *got != tt.want {
+				t.Errorf("value() = %v, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     interface{}
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "time", raw: time.Date(2021, 3, 1, 12, 30, 0, 0, time.UTC), want: time.Date(2021, 3, 1, 12, 30, 0, 0, time.UTC)},
+		{name: "db2 timestamp", raw: "2021-03-01-12.30.00.000001", want: time.Date(2021, 3, 1, 12, 30, 0, 1000, time.UTC)},
+		{name: "iso timestamp", raw: []byte("2021-03-01 12:30:00"), want: time.Date(2021, 3, 1, 12, 30, 0, 0, time.UTC)},
+		{name: "date", raw: " 2021-03-01 ", want: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "time of day falls on year 1", raw: "12.30.00", want: time.Date(1, 1, 1, 12, 30, 0, 0, time.UTC)},
+		{name: "null", raw: nil, wantErr: true},
+		{name: "unparseable", raw: "yesterday", wantErr: true},
+		{name: "unsupported type", raw: int64(1), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTime(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTime() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseTime() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColumnScannerTypes(t *testing.T) {
+	bytesType := reflect.TypeOf([]byte(nil))
+	tests := []struct {
+		name      string
+		column    fakeColumn
+		value     driver.Value
+		wantType  data.FieldType
+		wantValue interface{}
+	}{
+		{name: "for bit data", column: fakeColumn{dbType: "CHAR () FOR BIT DATA", scanType: bytesType}, value: []byte{0xde, 0xad}, wantType: data.FieldTypeNullableString, wantValue: "3q0="},
+		{name: "blob", column: fakeColumn{dbType: "BLOB", scanType: bytesType}, value: []byte("db2"), wantType: data.FieldTypeNullableString, wantValue: "ZGIy"},
+		{name: "smallint", column: fakeColumn{dbType: "SMALLINT"}, value: int64(7), wantType: data.FieldTypeNullableInt16, wantValue: int16(7)},
+		{name: "integer", column: fakeColumn{dbType: "INTEGER"}, value: int64(7), wantType: data.FieldTypeNullableInt32, wantValue: int32(7)},
+		{name: "bigint", column: fakeColumn{dbType: "BIGINT"}, value: int64(7), wantType: data.FieldTypeNullableInt64, wantValue: int64(7)},
+		{name: "double", column: fakeColumn{dbType: "DOUBLE"}, value: 1.5, wantType: data.FieldTypeNullableFloat64, wantValue: 1.5},
+		{name: "varchar", column: fakeColumn{dbType: "VARCHAR"}, value: []byte("db2"), wantType: data.FieldTypeNullableString, wantValue: "db2"},
+		{name: "xml", column: fakeColumn{dbType: "XML", scanType: bytesType}, value: []byte("<a/>"), wantType: data.FieldTypeNullableString, wantValue: "<a/>"},
+		{name: "other types are inferred", column: fakeColumn{dbType: "DATALINK"}, value: "http://db2/a", wantType: data.FieldTypeNullableString, wantValue: "http://db2/a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.column.name = "VALUE"
+			rows := queryFake(t, &fakeResult{
+				columns: []fakeColumn{tt.column},
+				rows:    [][]driver.Value{{tt.value}},
+			})
+
+			frame, _, err := frameFromRows(rows, scanOptions{noTimeColumn: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			field := frame.Fields[0]
+			if field.Type() != tt.wantType {
+				t.Fatalf("field type = %s, want %s", field.Type(), tt.wantType)
+			}
+			got, ok := field.ConcreteAt(0)
+			if !ok || got != tt.wantValue {
+				t.Errorf("value = %v, want %v", got, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestAsUTC(t *testing.T) {
+	amsterdam := time.FixedZone("CET", 3600)
+	tests := []struct {
+		name string
+		t    time.Time
+		want time.Time
+	}{
+		{name: "utc", t: time.Date(2021, 3, 1, 12, 30, 0, 5, time.UTC), want: time.Date(2021, 3, 1, 12, 30, 0, 5, time.UTC)},
+		{name: "local wall clock is kept", t: time.Date(2021, 3, 1, 12, 30, 0, 5, amsterdam), want: time.Date(2021, 3, 1, 12, 30, 0, 5, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := asUTC(tt.t)
+			if !got.Equal(tt.want) || got.Location() != time.UTC {
+				t.Errorf("asUTC() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// scanColumn returns the values of a single column result as frameFromRows reads them,
+// nil for a null.
+func scanColumn(t *testing.T, column fakeColumn, values []driver.Value, opts scanOptions) []interface{} {
+	rows := make([][]driver.Value, len(values))
+	for i, v := range values {
+		rows[i] = []driver.Value{v}
+	}
+	column.name = "VALUE"
+	opts.noTimeColumn = true
+
+	frame, _, err := frameFromRows(queryFake(t, &fakeResult{columns: []fakeColumn{column}, rows: rows}), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]interface{}, len(values))
+	for i := range got {
+		if v, ok := frame.Fields[0].ConcreteAt(i); ok {
+			got[i] = v
+		}
+	}
+	return got
+}
+
+func TestCharTrimming(t *testing.T) {
+	values := []driver.Value{"db2       ", "  db2     ", "          ", nil}
+	tests := []struct {
+		name   string
+		dbType string
+		trim   bool
+		want   []interface{}
+	}{
+		{name: "char trimmed", dbType: "CHAR", trim: true, want: []interface{}{"db2", "  db2", "", nil}},
+		{name: "char padding kept", dbType: "CHAR", want: []interface{}{"db2       ", "  db2     ", "          ", nil}},
+		{name: "varchar never trimmed", dbType: "VARCHAR", trim: true, want: []interface{}{"db2       ", "  db2     ", "          ", nil}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := scanColumn(t, fakeColumn{dbType: tt.dbType}, values, scanOptions{trimChar: tt.trim})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("values = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmptyStringAsNull(t *testing.T) {
+	tests := []struct {
+		name   string
+		dbType string
+		values []driver.Value
+		opts   scanOptions
+		want   []interface{}
+	}{
+		{name: "varchar", dbType: "VARCHAR", values: []driver.Value{"db2", "", nil}, opts: scanOptions{emptyStringAsNull: true}, want: []interface{}{"db2", nil, nil}},
+		{name: "varchar kept", dbType: "VARCHAR", values: []driver.Value{"db2", "", nil}, want: []interface{}{"db2", "", nil}},
+		{name: "blank varchar is not empty", dbType: "VARCHAR", values: []driver.Value{" "}, opts: scanOptions{emptyStringAsNull: true}, want: []interface{}{" "}},
+		{name: "trimmed char", dbType: "CHAR", values: []driver.Value{"db2  ", "     "}, opts: scanOptions{emptyStringAsNull: true, trimChar: true}, want: []interface{}{"db2", nil}},
+		{name: "untrimmed char", dbType: "CHAR", values: []driver.Value{"     "}, opts: scanOptions{emptyStringAsNull: true}, want: []interface{}{"     "}},
+		{name: "clob", dbType: "CLOB", values: []driver.Value{"text", ""}, opts: scanOptions{emptyStringAsNull: true}, want: []interface{}{"text", nil}},
+		{name: "vargraphic", dbType: "VARGRAPHIC", values: []driver.Value{"text", ""}, opts: scanOptions{emptyStringAsNull: true}, want: []interface{}{"text", nil}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := scanColumn(t, fakeColumn{dbType: tt.dbType}, tt.values, tt.opts)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("values = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlagColumns(t *testing.T) {
+	tests := []struct {
+		name   string
+		dbType string
+		values []driver.Value
+		opts   scanOptions
+		want   []interface{}
+	}{
+		{
+			name:   "Y and N",
+			dbType: "CHAR",
+			values: []driver.Value{"Y", "N", "y", "Y ", nil},
+			opts:   scanOptions{boolColumns: []string{"value"}},
+			want:   []interface{}{true, false, true, true, nil},
+		},
+		{
+			name:   "SMALLINT",
+			dbType: "SMALLINT",
+			values: []driver.Value{int64(1), int64(0)},
+			opts:   scanOptions{boolColumns: []string{"VALUE"}},
+			want:   []interface{}{true, false},
+		},
+		{
+			name:   "truthy values",
+			dbType: "CHAR",
+			values: []driver.Value{"J", "N", "Y"},
+			opts:   scanOptions{boolColumns: []string{"VALUE"}, truthyValues: []string{"J"}},
+			want:   []interface{}{true, false, false},
+		},
+		{
+			name:   "not a flag column",
+			dbType: "CHAR",
+			values: []driver.Value{"Y", "N"},
+			opts:   scanOptions{boolColumns: []string{"ACTIVE"}},
+			want:   []interface{}{"Y", "N"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := scanColumn(t, fakeColumn{dbType: tt.dbType}, tt.values, tt.opts)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("values = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeFieldNames(t *testing.T) {
+	tests := []struct {
+		fieldNameCase string
+		want          []string
+	}{
+		{"", []string{"HOST", "cpuPct", "Mem_Used"}},
+		{fieldNameCasePreserve, []string{"HOST", "cpuPct", "Mem_Used"}},
+		{fieldNameCaseLower, []string{"host", "cpupct", "mem_used"}},
+		{fieldNameCaseUpper, []string{"HOST", "CPUPCT", "MEM_USED"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fieldNameCase, func(t *testing.T) {
+			frame := data.NewFrame("",
+				data.NewField("HOST", nil, []string{}),
+				data.NewField("cpuPct", nil, []float64{}),
+				data.NewField("Mem_Used", nil, []int64{}),
+			)
+			normalizeFieldNames(frame, tt.fieldNameCase)
+			for i, name := range tt.want {
+				if got := frame.Fields[i].Name; got != name {
+					t.Errorf("field %d = %s, want %s", i, got, name)
+				}
+			}
+		})
+	}
+}
+
+func TestCompositeTimeColumn(t *testing.T) {
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	timeOfDay := time.Date(0, 1, 1, 13, 45, 30, 500000000, time.UTC)
+	columns := []fakeColumn{{name: "LOG_DATE", dbType: "DATE"}, {name: "VALUE", dbType: "DOUBLE"}, {name: "LOG_TIME", dbType: "TIME"}}
+
+	tests := []struct {
+		name            string
+		row             []driver.Value
+		timeOfDayColumn string
+		want            *time.Time
+		wantErr         bool
+	}{
+		{name: "date and time", row: []driver.Value{day, 1.5, timeOfDay}, want: timePtr(day.Add(13*time.Hour + 45*time.Minute + 30*time.Second + 500*time.Millisecond))},
+		{name: "null time is midnight", row: []driver.Value{day, 1.5, nil}, want: timePtr(day)},
+		{name: "null date", row: []driver.Value{nil, 1.5, timeOfDay}},
+		{name: "time column not found", row: []driver.Value{day, 1.5, timeOfDay}, timeOfDayColumn: "TS_TIME", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := scanOptions{timeColumn: "LOG_DATE", timeOfDayColumn: "LOG_TIME"}
+			if tt.timeOfDayColumn != "" {
+				opts.timeOfDayColumn = tt.timeOfDayColumn
+			}
+			frame, _, err := frameFromRows(queryFake(t, &fakeResult{columns: columns, rows: [][]driver.Value{tt.row}}), opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("frameFromRows() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if len(frame.Fields) != 2 || frame.Fields[0].Name != "LOG_DATE" || frame.Fields[1].Name != "VALUE" {
+				t.Fatalf("frameFromRows() fields = %d, want LOG_DATE and VALUE", len(frame.Fields))
+			}
+			got := frame.Fields[0].At(0).(*time.Time)
+			if (got == nil) != (tt.want == nil) || got != nil && !got.Equal(*tt.want) {
+				t.Errorf("time = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
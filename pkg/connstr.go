@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// connParam is a single KEY=VALUE attribute of a connection string.
+type connParam struct {
+	key   string
+	value string
+}
+
+// connParams is an ordered set of connection string attributes, keys are case-insensitive.
+type connParams []connParam
+
+// set replaces the value of key, or appends it when it isn't set yet.
+func (p *connParams) set(key, value string) {
+	for i := range *p {
+		if strings.EqualFold((*p)[i].key, key) {
+			(*p)[i].value = value
+			return
+		}
+	}
+	*p = append(*p, connParam{key: key, value: value})
+}
+
+// get returns the value of key, or "" when it isn't set.
+func (p connParams) get(key string) string {
+	for _, param := range p {
+		if strings.EqualFold(param.key, key) {
+			return param.value
+		}
+	}
+	return ""
+}
+
+func (p connParams) String() string {
+	attrs := make([]string, len(p))
+	for i, param := range p {
+		attrs[i] = param.key + "=" + param.value
+	}
+	return strings.Join(attrs, ";")
+}
+
+// parseConnectionString parses "KEY=VALUE;KEY=VALUE" attributes. A key that occurs twice
+// is rejected, as it is ambiguous which value is meant.
+func parseConnectionString(s string) (connParams, error) {
+	var params connParams
+	seen := make(map[string]bool)
+
+	for _, attr := range strings.Split(s, ";") {
+		attr = strings.TrimSpace(attr)
+		if attr == "" {
+			continue
+		}
+
+		kv := strings.SplitN(attr, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || key == "" {
+			return nil, fmt.Errorf("invalid connection string attribute %q", attr)
+		}
+
+		if seen[strings.ToUpper(key)] {
+			return nil, fmt.Errorf("connection string attribute %s is set more than once", key)
+		}
+		seen[strings.ToUpper(key)] = true
+
+		params = append(params, connParam{key: key, value: strings.TrimSpace(kv[1])})
+	}
+
+	return params, nil
+}
+
+// Authentication methods that can be set in the datasource settings.
+const (
+	authenticationPassword = "password"
+	authenticationKerberos = "kerberos"
+)
+
+func validAuthentication(a string) error {
+	switch a {
+	case "", authenticationPassword, authenticationKerberos:
+		return nil
+	}
+	return fmt.Errorf("unknown authentication %q", a)
+}
+
+// connectionString builds the connection string to the given host, the other settings
+// are shared by the primary and the replica.
+//
+// The structured settings come first. Attributes of the advanced connection string
+// override them, and the password from the secure settings is always set last. The
+// advanced connection string can't hold the credentials. With Kerberos, the credentials
+// come from the ticket of the Grafana process, so there's neither a user nor a password.
+func connectionString(host, port string, dso myDataSourceOptions, password string) (string, error) {
+	kerberos := dso.Authentication == authenticationKerberos
+
+	params := connParams{
+		{key: "HOSTNAME", value: host},
+		{key: "PORT", value: port},
+		{key: "DATABASE", value: dso.Database},
+	}
+	if kerberos {
+		params.set("AUTHENTICATION", "KERBEROS")
+	} else {
+		params.set("UID", dso.User)
+	}
+
+	//Unqualified table names resolve against the default schema.
+	if dso.Schema != "" {
+		params.set("CurrentSchema", dso.Schema)
+	}
+
+	//Unqualified procedures and functions resolve along the path, on every pooled connection.
+	path, err := currentFunctionPath(dso.CurrentPath, dso.User)
+	if err != nil {
+		return "", err
+	}
+	if path != "" {
+		params.set("CurrentFunctionPath", path)
+	}
+
+	//Encrypt the connection, optionally verifying the server against a certificate file.
+	if dso.SSL {
+		params.set("PROTOCOL", "TCPIP")
+		params.set("Security", "SSL")
+		if dso.SSLServerCertificate != "" {
+			params.set("SSLServerCertificate", dso.SSLServerCertificate)
+		}
+	}
+
+	//Give up on an unreachable server after the timeout, rather than the OS TCP timeout.
+	if dso.ConnectTimeout > 0 {
+		params.set("ConnectTimeout", strconv.Itoa(dso.ConnectTimeout))
+	}
+
+	//Rows the server sends per block, fewer round trips for large results over a slow link.
+	if dso.FetchSize > 0 {
+		params.set("BlockForNRows", strconv.Itoa(dso.FetchSize))
+	}
+
+	//Let Db2 replace literals by parameter markers, so literal-varying queries share a package cache entry.
+	if dso.StatementConcentrator {
+		params.set("StmtConcentrator", "WITHLITERALS")
+	}
+
+	advanced, err := parseConnectionString(dso.ConnectionString)
+	if err != nil {
+		return "", err
+	}
+	for _, param := range advanced {
+		if strings.EqualFold(param.key, "PWD") {
+			return "", fmt.Errorf("the connection string can't contain a password, use the password setting")
+		}
+		//The credentials come from the settings only, so the user can't change with the password kept.
+		if strings.EqualFold(param.key, "UID") {
+			return "", fmt.Errorf("the connection string can't contain a user, use the user setting")
+		}
+		params.set(param.key, param.value)
+	}
+
+	//Catch an incomplete configuration here, rather than as a connection error on the first query.
+	var missing []string
+	for _, required := range []struct{ key, setting string }{
+		{"HOSTNAME", "host"},
+		{"PORT", "port"},
+		{"DATABASE", "database"},
+	} {
+		if strings.TrimSpace(params.get(required.key)) == "" {
+			missing = append(missing, required.setting)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing connection settings: %s", strings.Join(missing, ", "))
+	}
+
+	if !kerberos {
+		params.set("PWD", password)
+	}
+
+	return params.String(), nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestConnectionString(t *testing.T) {
+	base := myDataSourceOptions{Database: "SAMPLE", User: "db2inst1"}
+
+	tests := []struct {
+		name    string
+		dso     func(dso *myDataSourceOptions)
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "structured settings",
+			dso:  func(dso *myDataSourceOptions) {},
+			want: "HOSTNAME=db2.example.com;PORT=50000;DATABASE=SAMPLE;UID=db2inst1;PWD=secret",
+		},
+		{
+			name: "statement concentrator",
+			dso:  func(dso *myDataSourceOptions) { dso.StatementConcentrator = true },
+			want: "HOSTNAME=db2.example.com;PORT=50000;DATABASE=SAMPLE;UID=db2inst1;StmtConcentrator=WITHLITERALS;PWD=secret",
+		},
+		{
+			name: "advanced port wins",
+			dso:  func(dso *myDataSourceOptions) { dso.ConnectionString = "port=50001;CurrentSchema=APP" },
+			want: "HOSTNAME=db2.example.com;PORT=50001;DATABASE=SAMPLE;UID=db2inst1;CurrentSchema=APP;PWD=secret",
+		},
+		{
+			name:    "advanced password",
+			dso:     func(dso *myDataSourceOptions) { dso.ConnectionString = "PWD=other" },
+			wantErr: true,
+		},
+		{
+			name:    "advanced user",
+			dso:     func(dso *myDataSourceOptions) { dso.ConnectionString = "Uid=other" },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dso := base
+			tt.dso(&dso)
+
+			got, err := connectionString("db2.example.com", "50000", dso, "secret")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("connectionString() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("connectionString() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
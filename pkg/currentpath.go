@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// systemPath holds the schemas of the SYSTEM PATH special register.
+var systemPath = []string{"SYSIBM", "SYSFUN", "SYSPROC", "SYSIBMADM"}
+
+// currentFunctionPath returns the value of the CurrentFunctionPath CLI attribute for a comma
+// separated list of schemas, which unqualified procedure and function names are resolved
+// with. The attribute takes quoted schema names only, so SYSTEM PATH is expanded to its
+// schemas, and CURRENT PATH to the default path of user. Unquoted names fold to upper case,
+// like they would in SET CURRENT PATH. An empty list returns no path.
+func currentFunctionPath(path, user string) (string, error) {
+	if strings.TrimSpace(path) == "" {
+		return "", nil
+	}
+
+	var schemas []string
+	seen := make(map[string]bool)
+	add := func(names ...string) {
+		//Db2 rejects a path that names a schema twice.
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				schemas = append(schemas, `"`+name+`"`)
+			}
+		}
+	}
+
+	for _, schema := range strings.Split(path, ",") {
+		schema = strings.Join(strings.Fields(schema), " ")
+
+		switch strings.ToUpper(schema) {
+		case "SYSTEM PATH":
+			add(systemPath...)
+			continue
+		case "CURRENT PATH", "CURRENT_PATH":
+			add(systemPath...)
+			if user != "" {
+				add(strings.ToUpper(user))
+			}
+			continue
+		}
+
+		if err := validIdentifier(schema); err != nil {
+			return "", fmt.Errorf("invalid current path: %w", err)
+		}
+		add(strings.ToUpper(schema))
+	}
+
+	return strings.Join(schemas, ","), nil
+}
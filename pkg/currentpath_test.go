@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestCurrentFunctionPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		user    string
+		want    string
+		wantErr bool
+	}{
+		{name: "empty", path: " ", want: ""},
+		{name: "schemas fold to upper case", path: "app, Reports", want: `"APP","REPORTS"`},
+		{name: "system path", path: "app, SYSTEM  PATH", want: `"APP","SYSIBM","SYSFUN","SYSPROC","SYSIBMADM"`},
+		{name: "current path adds the user", path: "app, current path", user: "db2inst1", want: `"APP","SYSIBM","SYSFUN","SYSPROC","SYSIBMADM","DB2INST1"`},
+		{name: "current path without a user", path: "CURRENT_PATH", want: `"SYSIBM","SYSFUN","SYSPROC","SYSIBMADM"`},
+		{name: "duplicates are dropped", path: "sysproc, system path, app, APP", want: `"SYSPROC","SYSIBM","SYSFUN","SYSIBMADM","APP"`},
+		{name: "invalid schema", path: `app, x";DROP`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := currentFunctionPath(tt.path, tt.user)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("currentFunctionPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("currentFunctionPath() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
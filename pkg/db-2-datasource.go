@@ -2,25 +2,28 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
-	_ "database/sql"
-
 	db2 "github.com/ibmdb/go_ibm_db"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 )
 
 // newDatasource returns datasource.ServeOpts.
 func newDatasource() datasource.ServeOpts {
 
-	log.DefaultLogger.Warn("Creating new Db2 datasource")
+	log.DefaultLogger.Debug("Creating new Db2 datasource")
 
 	// creates a instance manager for your plugin. The function passed
 	// into `NewInstanceManger` is called when the instance is created
@@ -32,8 +35,9 @@ func newDatasource() datasource.ServeOpts {
 	}
 
 	return datasource.ServeOpts{
-		QueryDataHandler:   ds,
-		CheckHealthHandler: ds,
+		QueryDataHandler:    ds,
+		CheckHealthHandler:  ds,
+		CallResourceHandler: httpadapter.New(ds.newResourceMux()),
 	}
 }
 
@@ -46,6 +50,10 @@ type Db2Datasource struct {
 	im instancemgmt.InstanceManager
 }
 
+// maxConcurrentQueries bounds the queries of a single request that run at the same time.
+// The pool limiter still bounds the queries across all requests.
+const maxConcurrentQueries = 5
+
 // QueryData handles multiple queries and returns multiple responses.
 // req contains the queries []DataQuery (where each query contains RefID as a unique identifer).
 // The QueryDataResponse contains a map of RefID to the response for each query, and each response
@@ -55,36 +63,143 @@ func (td *Db2Datasource) QueryData(ctx context.Context, req *backend.QueryDataRe
 	//Get the instance settingsfor the current instance of the Db2Datasource.
 	instance, err := td.im.Get(req.PluginContext)
 	if err != nil {
-		log.DefaultLogger.Info("Failed getting PluginContext")
-		return nil, nil
+		log.DefaultLogger.Error("Failed getting PluginContext", "err", err)
+		return nil, err
 	}
 
 	instSetting, ok := instance.(*instanceSettings)
 	if !ok {
-		log.DefaultLogger.Info("Failed getting instance settings")
+		log.DefaultLogger.Error("Failed getting instance settings")
 		return nil, nil
 	}
 
 	//Do some logging.
-	log.DefaultLogger.Info("QueryData() - " + instSetting.name)
+	log.DefaultLogger.Debug("QueryData() - " + instSetting.name)
 
 	response := backend.NewQueryDataResponse()
 
-	// Loop over queries and execute them individually.
+	// Run the queries concurrently, at most maxConcurrentQueries at a time.
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, maxConcurrentQueries)
+
 	for _, q := range req.Queries {
-		res := td.query(ctx, instSetting, q)
+		wg.Add(1)
+		go func(q backend.DataQuery) {
+			defer wg.Done()
 
-		// Save the response in a hashmap based on with RefID as identifier
-		response.Responses[q.RefID] = res
+			var res backend.DataResponse
+			select {
+			case slots <- struct{}{}:
+				//Don't start queries for a request that was already cancelled.
+				if err := ctx.Err(); err != nil {
+					res = backend.DataResponse{Error: err}
+				} else {
+					start := time.Now()
+					res = td.query(ctx, instSetting, q)
+					observeQuery(instSetting.name, start, res.Error == nil)
+				}
+				<-slots
+			case <-ctx.Done():
+				res = backend.DataResponse{Error: ctx.Err()}
+			}
+
+			// Save the response in a hashmap based on with RefID as identifier
+			mu.Lock()
+			response.Responses[q.RefID] = res
+			mu.Unlock()
+		}(q)
 	}
+	wg.Wait()
+
+	//Queries that share a union group are combined into a single frame.
+	unionQueries(req.Queries, response)
 
 	return response, nil
 }
 
-//Query model consists of nothing but a raw query.
+//Query model consists of the raw query and its per-query options.
 type queryModel struct {
-	Hide      bool   `json:"hide"`
-	QueryText string `json:"queryText"`
+	Hide       bool   `json:"hide"`
+	QueryText  string `json:"queryText"`
+	FillMode   string `json:"fillMode"`
+	ErrorFrame bool   `json:"errorFrame"`
+	TrimChar   bool   `json:"trimChar"`
+
+	//Shape of the returned frame, see the format constants.
+	Format string `json:"format"`
+
+	//Store empty strings as null, for sources that mix both.
+	EmptyStringAsNull bool `json:"emptyStringAsNull"`
+
+	//Return DECIMAL and DECFLOAT columns as strings. DECFLOAT keeps all its digits, DECIMAL is
+	//read as a double by the driver and only written without an exponent.
+	DecimalAsString bool `json:"decimalAsString"`
+
+	//Characters kept of CLOB values and bytes of binary values, longer values are cut off. 0 uses the default.
+	MaxTextLength int `json:"maxTextLength"`
+
+	//Encoding of binary column values, "base64" (the default) or "hex".
+	BinaryEncoding string `json:"binaryEncoding"`
+
+	//The time column, the first column when not set. Legacy schemas can split the timestamp
+	//in a DATE and a TIME column, which get combined.
+	TimeColumn      string `json:"timeColumn"`
+	TimeOfDayColumn string `json:"timeOfDayColumn"`
+
+	//"epoch_s" or "epoch_ms" when the time column holds seconds or milliseconds since the
+	//Unix epoch, rather than a timestamp.
+	TimeColumnType string `json:"timeColumnType"`
+
+	//Skip rows with an unparseable time value instead of failing the query.
+	SkipBadTimeRows bool `json:"skipBadTimeRows"`
+
+	//Return the rows read before a scan error with a notice, instead of failing the query.
+	PartialOnError bool `json:"partialOnError"`
+
+	//Flag columns read as booleans, true when they hold one of the truthy values ('Y', 'T' or 1 by default).
+	BoolColumns  []string `json:"boolColumns"`
+	TruthyValues []string `json:"truthyValues"`
+
+	//Client attributes Db2 Workload Manager classifies the query on.
+	ClientUserID  string `json:"clientUserId"`
+	WorkloadClass string `json:"workloadClass"`
+
+	//Cast hints per column, overriding the detected field type.
+	FieldTypes map[string]string `json:"fieldTypes"`
+
+	//Display unit, decimals and name per column.
+	FieldConfig map[string]columnConfig `json:"fieldConfig"`
+
+	//Queries with the same union group get their rows combined into a single frame.
+	UnionGroup string `json:"unionGroup"`
+
+	//Values bound to the ? parameter markers of the query, in order.
+	Params []interface{} `json:"params"`
+
+	//Return a frame per value of the split column, e.g. one per device, instead of a single frame.
+	SplitColumn string `json:"splitColumn"`
+
+	//Keep only the rows where the value of FilterField compares to FilterValue with FilterOp.
+	FilterField string  `json:"filterField"`
+	FilterOp    string  `json:"filterOp"`
+	FilterValue float64 `json:"filterValue"`
+
+	//Round float fields to a number of decimal places, for all columns or per column.
+	DecimalPlaces       *int           `json:"decimalPlaces"`
+	ColumnDecimalPlaces map[string]int `json:"columnDecimalPlaces"`
+
+	//Add the min, max and last value of every numeric field to the frame's metadata.
+	ComputeStats bool `json:"computeStats"`
+
+	//Add a hash of the result's column names and types to the frame's metadata, to detect schema changes.
+	SchemaFingerprint bool `json:"schemaFingerprint"`
+
+	//Execute a statement that doesn't return rows, only allowed when the datasource enables it.
+	Exec bool `json:"exec"`
+
+	//Set when the query was made in the structured (no-SQL) editor.
+	Builder *queryBuilder `json:"builder"`
 }
 
 func (td *Db2Datasource) query(ctx context.Context, instance *instanceSettings, query backend.DataQuery) backend.DataResponse {
@@ -104,71 +219,552 @@ func (td *Db2Datasource) query(ctx context.Context, instance *instanceSettings,
 		return response
 	}
 
+	response.Error = validFormat(qm.Format)
+	if response.Error != nil {
+		return response
+	}
+
+	response.Error = validBinaryEncoding(qm.BinaryEncoding)
+	if response.Error != nil {
+		return response
+	}
+
+	response.Error = validTimeColumnType(qm.TimeColumnType)
+	if response.Error != nil {
+		return response
+	}
+
+	//A structured query is turned into SQL by the builder.
+	if qm.Builder != nil {
+		qm.QueryText, response.Error = qm.Builder.sql()
+		if response.Error != nil {
+			return response
+		}
+	}
+
+	qm.QueryText, response.Error = expandMacros(qm.QueryText, query)
+	if response.Error != nil {
+		return response
+	}
+
+	args, err := queryArgs(qm.Params, query.TimeRange)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	//Protect against runaway queries that don't limit their rows themselves.
+	rowLimit := queryRowLimit(qm, query.MaxDataPoints, instance.autoLimit)
+
+	var rowLimited bool
+	if !qm.Exec {
+		qm.QueryText, rowLimited = injectRowLimit(qm.QueryText, rowLimit)
+	}
+
+	if instance.readOnly && !isReadOnly(qm.QueryText) {
+		response.Error = fmt.Errorf("the datasource is read-only, only SELECT, WITH and VALUES statements that don't change data can run")
+		return response
+	}
+
+	if qm.Exec && !instance.allowExec {
+		response.Error = fmt.Errorf("executing statements is not enabled for this datasource")
+		return response
+	}
+
 	//************************************
 	// Db2 stuff
 	//************************************
-	// Open connection from the pool
-	db := instance.pool.Open(instance.constr, "SetConnMaxLifetime=60")
-	defer db.Close()
+	// Wait for the concurrency limit of the current pool window. Under load, fail fast with
+	// a busy error rather than keeping the panel waiting.
+	err = instance.limiter.acquire(ctx, instance.busyTimeout)
+	if err != nil {
+		response.Error = err
+		return response
+	}
 
-	// Run the query
-	rows, err := db.Query(qm.QueryText)
-	defer rows.Close()
+	//The slot is held until the statements of the query are done on Db2. One abandoned at
+	//its deadline keeps running there, its slot is freed when it finishes.
+	var running sync.WaitGroup
+	defer func() {
+		go func() {
+			running.Wait()
+			instance.limiter.release()
+		}()
+	}()
+	tracked := func(op func() error) func() error {
+		running.Add(1)
+		return func() error {
+			defer running.Done()
+			return op()
+		}
+	}
 
-	if err != nil {
-		log.DefaultLogger.Info("Query() - Failed running query")
-		log.DefaultLogger.Warn(err.Error())
-	} else {
-		//Get names of columns, they will be used as names for the series.
-		colNames, err := rows.Columns()
+	//Bound the run time of the query, on top of the cancellation of the request.
+	ctx, cancel := context.WithTimeout(ctx, instance.queryTimeout)
+	defer cancel()
+
+	start := time.Now()
+
+	// Get the instance's long-lived handle to the pool
+	db := instance.open()
+
+	//Statements like the UPDATE behind a dashboard action report how many rows they changed.
+	if qm.Exec {
+		var executed *data.Frame
+		err = awaitDone(ctx, tracked(func() (err error) {
+			executed, err = execFrame(ctx, &db.DB, qm.QueryText, args)
+			return err
+		}), nil)
+		err = timeoutError(ctx, err, instance.queryTimeout)
 		if err != nil {
-			log.DefaultLogger.Warn("Query() - Failed to get rows.Columns()")
+			response.Error = withDiagnostics(err)
+			log.DefaultLogger.Warn("Query() - failed executing statement", queryLogArgs(query.RefID, qm.QueryText, start, err)...)
+			return response
 		}
+		frame = executed
+		setExecutionMeta(frame, start, 0, false)
+		setExecutedQuery(frame, qm.QueryText)
+		response.Frames = append(response.Frames, frame)
+		return response
+	}
 
-		//We use a non-sized slice of pointers to actual variables (in another slice) to get typeless pointers to every column's value in a given row.
-		//The values slice will then contain actual usable values that are returned from the database.
-		colPtrs := make([]interface{}, len(colNames))
-		values := make([]int64, len(colNames)-1)
+	// Run the query, on the read-only replica when there is one, falling back to the primary
+	// when the replica is unavailable, and skipping it for a while after. Transient connection errors are retried, exec
+	// statements above aren't, as they may have been applied.
+	var rows *sql.Rows
+	var release func()
+	info := clientInfo{userID: qm.ClientUserID, applName: qm.WorkloadClass}
 
-		var timeColumn time.Time   //Single time value to receive first column of scanned row in.
-		var timeSeries []time.Time //Slice to save those single values from each row.
+	//A query abandoned at its deadline closes its rows once the driver returns them.
+	err = awaitDone(ctx, tracked(func() error {
+		return instance.retry.do(ctx, func() error {
+			var err error
+			if replica := instance.openReplica(); replica != nil && instance.replicaStatus.available() && isSelect(maskSQL(qm.QueryText)) {
+				rows, release, err = runQuery(ctx, &replica.DB, info, qm.QueryText, args)
+				if err != nil && isConnectionError(err) {
+					log.DefaultLogger.Warn("Query() - replica unavailable, falling back to primary", "err", err, "backoff", replicaBackoff)
+					instance.replicaStatus.markDown()
+					rows, release, err = runQuery(ctx, &db.DB, info, qm.QueryText, args)
+				}
+			} else {
+				rows, release, err = runQuery(ctx, &db.DB, info, qm.QueryText, args)
+			}
+			return err
+		})
+	}), func() {
+		if rows != nil {
+			rows.Close()
+			release()
+		}
+	})
+	err = timeoutError(ctx, err, instance.queryTimeout)
 
-		dataSeriesMap := make(map[int][]int64) //This map has a slice of int64's for each column, except the first (timeSeries) time column.
+	//A limited query returns at most rowLimit rows, which sizes the fields up front.
+	var rowCapacity int
+	if rowLimited {
+		rowCapacity = int(rowLimit)
+	}
+
+	var fetched int
+	var incomplete []string
+	if err != nil {
+		log.DefaultLogger.Warn("Query() - failed running query", queryLogArgs(query.RefID, qm.QueryText, start, err)...)
 
-		//First column is the time column.
-		colPtrs[0] = &timeColumn
-		// Other columns are always int64.
-		for i := range colNames[1:] {
-			colPtrs[i+1] = &values[i]
+		//Return the Db2 diagnostics as a frame if the query asks for it, otherwise show the error on the panel.
+		if !qm.ErrorFrame {
+			response.Error = withDiagnostics(err)
+			return response
 		}
+		frame = errorFrame(err)
+	} else {
+		//Rows are only there when the query succeeded. They're read and closed in the goroutine
+		//of awaitDone, a Close here would wait for the fetch it should abandon.
+		var read *data.Frame
+		var cutOff string
+		err = awaitDone(ctx, tracked(func() (err error) {
+			defer release()
+			defer rows.Close()
 
-		for rows.Next() {
-			err = rows.Scan(colPtrs...)
+			read, cutOff, err = frameFromRows(rows, scanOptions{
+				trimChar:          qm.TrimChar,
+				emptyStringAsNull: qm.EmptyStringAsNull,
+				decimalAsString:   qm.DecimalAsString,
+				maxTextLength:     qm.MaxTextLength,
+				binaryEncoding:    qm.BinaryEncoding,
+				timeColumn:        qm.TimeColumn,
+				timeColumnType:    qm.TimeColumnType,
+				timeOfDayColumn:   qm.TimeOfDayColumn,
+				skipBadTimeRows:   qm.SkipBadTimeRows,
+				partialOnError:    qm.PartialOnError,
+				maxRows:           instance.maxRows,
+				boolColumns:       qm.BoolColumns,
+				truthyValues:      qm.TruthyValues,
+				rowCapacity:       rowCapacity,
+				noTimeColumn:      qm.Format == formatTable || qm.Format == formatAnnotations,
+			})
+			return err
+		}), nil)
+		err = timeoutError(ctx, err, instance.queryTimeout)
+		if err != nil {
+			log.DefaultLogger.Warn("Query() - failed reading rows", queryLogArgs(query.RefID, qm.QueryText, start, err)...)
+			if qm.ErrorFrame {
+				response.Frames = append(response.Frames, errorFrame(err))
+				return response
+			}
+			response.Error = withDiagnostics(err)
+			return response
+		}
+		frame = read
+		fetched, _ = frame.RowLen()
+		if cutOff != "" {
+			incomplete = append(incomplete, cutOff)
+		}
+
+		//Cast hints override the types detected from the columns.
+		err = applyFieldTypes(frame, qm.FieldTypes)
+		if err != nil {
+			response.Error = err
+			return response
+		}
+
+		normalizeFieldNames(frame, instance.fieldNameCase)
+
+		//A split query returns a frame per value of the split column, and log lines a frame
+		//per set of labels, each shaped on its own.
+		frames := []*data.Frame{frame}
+		switch {
+		case len(frame.Fields) == 0:
+			//Nothing to split or shape, the panel shows no data.
+		case qm.Format == formatLogs:
+			frames, err = logFrames(frame)
+		case qm.SplitColumn != "":
+			frames, err = splitFrame(frame, qm.SplitColumn)
+		}
+		if err != nil {
+			response.Error = err
+			return response
+		}
 
+		for _, frame := range frames {
+			frame, err = shapeFrame(frame, query, qm, instance)
 			if err != nil {
-				log.DefaultLogger.Warn("Query() - Failed to do rows.Scan()")
+				response.Error = err
+				return response
 			}
+			response.Frames = append(response.Frames, frame)
+		}
+	}
+
+	//The error frame wasn't added yet.
+	if len(response.Frames) == 0 {
+		response.Frames = append(response.Frames, frame)
+	}
 
-			timeSeries = append(timeSeries, timeColumn)
+	//A query that got the automatic row limit and returned that many rows is probably cut off,
+	//like one that hit the row cap or stopped reading at an error. The notices and the
+	//execution meta describe the whole query, they're set on the first frame.
+	if rowLimited && int64(fetched) >= rowLimit {
+		incomplete = append(incomplete, fmt.Sprintf("The result was limited to %d rows", rowLimit))
+	}
+	for _, text := range incomplete {
+		addNotice(response.Frames[0], data.NoticeSeverityWarning, text)
+	}
+	setExecutionMeta(response.Frames[0], start, fetched, len(incomplete) > 0)
+	for _, frame := range response.Frames {
+		setExecutedQuery(frame, qm.QueryText)
+	}
+
+	log.DefaultLogger.Debug("Query() - done", append(queryLogArgs(query.RefID, qm.QueryText, start, nil), "rows", fetched)...)
+
+	return response
+}
 
-			for i, value := range values {
-				dataSeriesMap[i] = append(dataSeriesMap[i], value)
+// queryRowLimit returns the row limit added to a query, 0 for none. A wide time series is
+// also limited to the data points the panel can show, a row is a point of every series.
+// In other formats a row is a point of a single series, or isn't drawn per point at all.
+func queryRowLimit(qm queryModel, maxDataPoints, autoLimit int64) int64 {
+	wide := (qm.Format == formatDefault || qm.Format == formatTimeSeries) && qm.SplitColumn == ""
+	if wide && maxDataPoints > 0 && (autoLimit <= 0 || maxDataPoints < autoLimit) {
+		return maxDataPoints
+	}
+	return autoLimit
+}
+
+// shapeFrame turns a scanned frame into the requested format, and applies the per-query
+// filter, rounding, units and meta.
+func shapeFrame(frame *data.Frame, query backend.DataQuery, qm queryModel, instance *instanceSettings) (*data.Frame, error) {
+	var err error
+
+	//A result without columns is returned as an empty frame, whatever the format.
+	if len(frame.Fields) == 0 {
+		return frame, nil
+	}
+
+	//Wide frames are converted for the long format, tables, annotations and logs have no series, filling only applies to wide series.
+	switch qm.Format {
+	case formatLong:
+		frame, err = longFrame(frame)
+	case formatTable:
+		frame = tableFrame(frame)
+	case formatAnnotations:
+		frame, err = annotationFrame(frame)
+	case formatLogs:
+		frame = logsFrame(frame)
+	default:
+		//Fill the gaps in the series over the time range, based on the interval of the query.
+		frame, err = fillFrame(frame, query.TimeRange, query.Interval, qm.FillMode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	//Drop the rows that don't pass the threshold filter, across all fields so they stay aligned.
+	if qm.FilterField != "" {
+		frame, err = filterRows(frame, qm.FilterField, qm.FilterOp, qm.FilterValue)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	roundFloatFields(frame, qm.DecimalPlaces, qm.ColumnDecimalPlaces)
+	//The query's own config goes first, the datasource's units only fill in the rest.
+	applyFieldConfig(frame, qm.FieldConfig)
+	applyUnits(frame, instance.unitBySuffix)
+
+	if qm.ComputeStats {
+		setCustomMeta(frame, "stats", computeStats(frame))
+	}
+
+	if qm.SchemaFingerprint {
+		setCustomMeta(frame, "schemaFingerprint", schemaFingerprint(frame))
+	}
+
+	return frame, nil
+}
+
+// frameFromRows scans the result set into a frame. The time column is the first column,
+// unless another or a composite time column is set, the other columns get a field
+// typed after their Db2 column type. When not all rows were read, because of the row cap
+// or an error with partialOnError, incomplete says so, for the query's notices.
+func frameFromRows(rows *sql.Rows, opts scanOptions) (frame *data.Frame, incomplete string, err error) {
+	frame = data.NewFrame("response")
+
+	//Get the columns, their names will be used as names for the series.
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get rows.ColumnTypes(): %w", err)
+	}
+
+	//A statement without columns, like a CALL without result set, gives an empty frame.
+	if len(colTypes) == 0 {
+		return frame, incomplete, nil
+	}
+
+	//Every column gets a scanner that collects its values in a field.
+	//colPtrs holds the typeless pointers to each scanner's destination, to scan a row in.
+	scanners := make([]*columnScanner, len(colTypes))
+	colPtrs := make([]interface{}, len(colTypes))
+
+	//The time column is the named column or the first column. A DATE and a TIME column can
+	//be combined into the time column.
+	timeIdx, timeOfDayIdx := 0, -1
+	switch {
+	case opts.timeOfDayColumn != "":
+		timeIdx = columnIndex(colTypes, opts.timeColumn)
+		timeOfDayIdx = columnIndex(colTypes, opts.timeOfDayColumn)
+		if timeIdx < 0 || timeOfDayIdx < 0 {
+			return nil, "", fmt.Errorf("time columns %s and %s not found in the result", opts.timeColumn, opts.timeOfDayColumn)
+		}
+	case opts.timeColumn != "":
+		timeIdx = columnIndex(colTypes, opts.timeColumn)
+		if timeIdx < 0 {
+			return nil, "", fmt.Errorf("time column %s not found in the result", opts.timeColumn)
+		}
+	case opts.noTimeColumn:
+		timeIdx = -1
+	}
+
+	var inferred, lossy []string
+	for i, colType := range colTypes {
+		if lossyDecimal(colType) {
+			lossy = append(lossy, colType.Name())
+		}
+
+		switch {
+		case i == timeIdx && timeOfDayIdx >= 0:
+			scanners[timeIdx], scanners[timeOfDayIdx] = newCompositeTimeScanners(colType.Name(), colTypes[timeOfDayIdx].Name())
+		case i == timeOfDayIdx:
+			//Set up together with the time column.
+		case i == timeIdx && opts.timeColumnType != "":
+			scanners[i] = newEpochTimeScanner(colType.Name(), opts.timeColumnType, opts.skipBadTimeRows)
+		case i == timeIdx:
+			scanners[i] = newTimeScanner(colType.Name(), opts.skipBadTimeRows)
+		case !hasColumnType(colType):
+			//Without a reported type the field is typed after the values.
+			scanners[i] = newInferringScanner(colType.Name())
+			inferred = append(inferred, colType.Name())
+		default:
+			scanners[i] = newColumnScanner(colType, opts)
+		}
+	}
+
+	for i, scanner := range scanners {
+		colPtrs[i] = scanner.dest
+		if scanner.field != nil && opts.rowCapacity > 0 {
+			reserve(scanner.field, opts.rowCapacity)
+		}
+	}
+
+	skipped := 0
+	read := 0
+	//With partialOnError, the error that stopped reading, the rows read before it are kept.
+	var readErr error
+
+	capped := false
+
+	for rows.Next() {
+		//Another row past the cap, the rest isn't read. The caller closes the rows.
+		if opts.maxRows > 0 && int64(read) >= opts.maxRows {
+			capped = true
+			break
+		}
+
+		err = rows.Scan(colPtrs...)
+		if err != nil {
+			err = fmt.Errorf("failed to do rows.Scan(): %w", err)
+			if !opts.partialOnError {
+				return nil, "", err
 			}
+			readErr = err
+			break
+		}
 
+		//With skipBadTimeRows, a row with a time value that can't be converted is skipped as a
+		//whole, which keeps the fields aligned. Otherwise it fails like a scan error.
+		if err = checkRow(scanners); err != nil {
+			if opts.skipBadTimeRows {
+				log.DefaultLogger.Debug("Query() - skipping row", "err", err)
+				skipped++
+				continue
+			}
+			err = fmt.Errorf("failed converting a time value: %w", err)
+			if !opts.partialOnError {
+				return nil, "", err
+			}
+			readErr = err
+			break
 		}
 
-		//Hardcode the timeseries.
-		frame.Fields = append(frame.Fields, data.NewField(colNames[0], nil, timeSeries))
-		//Itterate over the rest of the columns.
-		for i, name := range colNames[1:] {
-			frame.Fields = append(frame.Fields, data.NewField(name, nil, dataSeriesMap[i]))
+		for _, scanner := range scanners {
+			scanner.append()
 		}
+		read++
+	}
 
+	//An error that ended the iteration early, the result would look complete without it.
+	if err = rows.Err(); err != nil && readErr == nil {
+		err = fmt.Errorf("failed reading rows: %w", err)
+		if !opts.partialOnError {
+			return nil, "", err
+		}
+		readErr = err
 	}
 
-	response.Frames = append(response.Frames, frame)
+	for _, scanner := range scanners {
+		if scanner.finish != nil {
+			scanner.field = scanner.finish()
+		}
+		if scanner.field != nil {
+			frame.Fields = append(frame.Fields, scanner.field)
+		}
+	}
 
-	return response
+	if readErr != nil {
+		log.DefaultLogger.Warn("Query() - returning partial result", "rows", read, "err", readErr)
+		incomplete = fmt.Sprintf("The result is incomplete, reading stopped after %d rows: %s", read, withDiagnostics(readErr))
+	}
+	if capped {
+		log.DefaultLogger.Warn("Query() - result cut off at the row cap", "maxRows", opts.maxRows)
+		incomplete = fmt.Sprintf("The result was cut off at %d rows, the most the datasource returns for a query", opts.maxRows)
+	}
+
+	var notices []data.Notice
+	if skipped > 0 {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("Skipped %d rows with an unparseable time value", skipped),
+		})
+	}
+	var truncated []string
+	for _, scanner := range scanners {
+		if scanner.truncated > 0 {
+			truncated = append(truncated, fmt.Sprintf("%d values of %s", scanner.truncated, scanner.field.Name))
+		}
+	}
+	if len(truncated) > 0 {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("Truncated %s to the maximum text length", strings.Join(truncated, ", ")),
+		})
+	}
+	if len(lossy) > 0 {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("The DECIMAL columns %s have more than %d digits, which are read as floating point and rounded. Cast them AS CHAR for the exact values", strings.Join(lossy, ", "), maxExactDecimalDigits),
+		})
+	}
+	if len(inferred) > 0 {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("The driver reported no type for %s, their types were inferred from the values", strings.Join(inferred, ", ")),
+		})
+	}
+	if len(notices) > 0 {
+		frame.Meta = &data.FrameMeta{Notices: notices}
+	}
+
+	return frame, incomplete, nil
+}
+
+// checkRow converts the scanned values of the scanners that check their values, and
+// returns the first conversion error.
+func checkRow(scanners []*columnScanner) error {
+	for _, scanner := range scanners {
+		if scanner.check == nil {
+			continue
+		}
+		if err := scanner.check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runQuery runs the query, on a dedicated connection when it carries client attributes
+// for WLM. The returned release func must be called after the rows are closed.
+func runQuery(ctx context.Context, db *sql.DB, info clientInfo, queryText string, args []interface{}) (*sql.Rows, func(), error) {
+	if info.empty() {
+		rows, err := db.QueryContext(ctx, queryText, args...)
+		return rows, func() {}, err
+	}
+
+	return queryWithClientInfo(ctx, db, info, queryText, args)
+}
+
+// execFrame executes a statement that doesn't return rows, and returns a frame
+// holding the number of rows it affected.
+func execFrame(ctx context.Context, db *sql.DB, statement string, args []interface{}) (*data.Frame, error) {
+	result, err := db.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	return data.NewFrame("response", data.NewField("rows_affected", nil, []int64{affected})), nil
 }
 
 // CheckHealth handles health checks sent from Grafana to the plugin.
@@ -176,118 +772,584 @@ func (td *Db2Datasource) query(ctx context.Context, instance *instanceSettings,
 // datasource configuration page which allows users to verify that
 // a datasource is working as expected.
 func (td *Db2Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
-	var status = backend.HealthStatusOk
-	var message = "MESSAGE NOT SET YET"
-
 	instance, err := td.im.Get(req.PluginContext)
 	if err != nil {
-		log.DefaultLogger.Info("Failed getting PluginContext")
-		return nil, nil
+		//The settings are invalid, tell the config page why.
+		log.DefaultLogger.Error("Failed getting PluginContext", "err", err)
+		return &backend.CheckHealthResult{
+			Status:  backend.HealthStatusError,
+			Message: err.Error(),
+		}, nil
 	}
 
 	instSetting, ok := instance.(*instanceSettings)
 	if !ok {
-		log.DefaultLogger.Info("Failed getting instance settings")
+		log.DefaultLogger.Error("Failed getting instance settings")
 		return nil, nil
 	}
 
-	log.DefaultLogger.Warn("Checkhealth() fired")
+	log.DefaultLogger.Debug("Checkhealth() fired")
+
+	//An overloaded Db2 shouldn't keep the test button spinning. go_ibm_db can't cancel a running
+	//call, so the probes run in their own goroutine and the check returns at the deadline while
+	//the statement keeps running on the server until Db2 finishes it.
+	ctx, cancel := context.WithTimeout(ctx, instSetting.healthCheckTimeout)
+	defer cancel()
+
+	//Buffered, an abandoned probe still finishes and closes its statement and rows.
+	done := make(chan *backend.CheckHealthResult, 1)
+	go func() {
+		done <- checkHealth(ctx, instSetting)
+	}()
+
+	var result *backend.CheckHealthResult
+	select {
+	case result = <-done:
+	case <-ctx.Done():
+	}
 
-	db := instSetting.pool.Open(instSetting.constr, "SetConnMaxLifetime=60")
-	st, err := db.Prepare("select current timestamp from sysibm.sysdummy1")
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.DefaultLogger.Warn("CheckHealth - timed out, the statement keeps running on the server", "timeout", instSetting.healthCheckTimeout)
+		result = &backend.CheckHealthResult{
+			Status:  backend.HealthStatusError,
+			Message: fmt.Sprintf("Health check timed out after %s", instSetting.healthCheckTimeout),
+		}
+	} else if result == nil {
+		//Grafana cancelled the request.
+		result = &backend.CheckHealthResult{
+			Status:  backend.HealthStatusError,
+			Message: "Health check cancelled: " + ctx.Err().Error(),
+		}
+	}
+
+	observeHealthCheck(instSetting.name, result.Status == backend.HealthStatusOk)
+	return result, nil
+}
+
+// checkHealth runs the health check query, the deep health check and the named checks.
+// The driver doesn't honour ctx once a call is running, CheckHealth enforces the deadline.
+func checkHealth(ctx context.Context, instSetting *instanceSettings) *backend.CheckHealthResult {
+	var status backend.HealthStatus
+	var message string
+
+	db := instSetting.open()
+	var st *sql.Stmt
+	err := instSetting.retry.do(ctx, func() (err error) {
+		st, err = db.PrepareContext(ctx, instSetting.healthCheckQuery)
+		return err
+	})
 
 	if err != nil {
-		log.DefaultLogger.Warn("CheckHealth - Failed on prepare")
-		log.DefaultLogger.Warn(err.Error())
+		log.DefaultLogger.Warn("CheckHealth - Failed on prepare", "err", err)
+		message = "Failed preparing the health check query: " + withDiagnostics(err).Error()
+		return &backend.CheckHealthResult{
+			Status:  backend.HealthStatusError,
+			Message: message,
+		}
 	}
+	defer st.Close()
 
-	log.DefaultLogger.Warn("CheckHealth - about to run query")
-	rows, err := st.Query()
+	//Only a successful round trip turns the check green.
+	status = backend.HealthStatusError
+	message = "Health check query returned no rows"
+
+	log.DefaultLogger.Debug("CheckHealth - about to run query")
+	var rows *sql.Rows
+	err = instSetting.retry.do(ctx, func() (err error) {
+		rows, err = st.QueryContext(ctx)
+		return err
+	})
 
 	if err != nil {
-		log.DefaultLogger.Warn("CheckHealth - error running query")
-		log.DefaultLogger.Warn(err.Error())
+		log.DefaultLogger.Warn("CheckHealth - error running query", "err", err)
+		message = "Failed running the health check query: " + withDiagnostics(err).Error()
 	} else {
 		if rows != nil {
-			log.DefaultLogger.Warn("CheckHealth - getting columns")
+			//Closed once, also when reading the columns fails.
+			defer rows.Close()
+
+			log.DefaultLogger.Debug("CheckHealth - getting columns")
 			cols, err := rows.Columns()
 
 			if err != nil {
-				log.DefaultLogger.Warn("CheckHealth - error getting columns")
-				log.DefaultLogger.Warn(err.Error())
+				log.DefaultLogger.Warn("CheckHealth - error getting columns", "err", err)
+				message = "Failed getting the health check columns: " + withDiagnostics(err).Error()
+			} else if len(cols) == 0 {
+				message = "Health check query returned no columns"
 			} else {
-				log.DefaultLogger.Warn(cols[0])
-
-				for rows.Next() {
-					var tme string
+				//The first value of the first row is shown, a custom query can return more.
+				if rows.Next() {
+					values := make([]sql.NullString, len(cols))
+					dest := make([]interface{}, len(cols))
+					for i := range values {
+						dest[i] = &values[i]
+					}
 
-					err := rows.Scan(&tme)
+					err := rows.Scan(dest...)
 					if err != nil {
-						log.DefaultLogger.Warn("CheckHealth - error scanning rows")
-						log.DefaultLogger.Warn(err.Error())
+						log.DefaultLogger.Warn("CheckHealth - error scanning rows", "err", err)
+						message = "Failed reading the health check result: " + withDiagnostics(err).Error()
 					} else {
-						log.DefaultLogger.Warn("Current time " + tme)
-						message = "Check succesful; current timestamp = " + tme
+						log.DefaultLogger.Debug("CheckHealth - " + cols[0] + " " + values[0].String)
+						status = backend.HealthStatusOk
+						if instSetting.healthCheckQuery == defaultHealthCheckQuery {
+							message = "Check succesful; current timestamp = " + values[0].String
+						} else {
+							message = "Check succesful; " + cols[0] + " = " + values[0].String
+						}
 					}
-
-					rows.Close()
+				} else if err := rows.Err(); err != nil {
+					//No row because reading failed, rather than an empty result.
+					log.DefaultLogger.Warn("CheckHealth - error reading rows", "err", err)
+					message = "Failed reading the health check result: " + withDiagnostics(err).Error()
 				}
 			}
 		}
 	}
 
-	db.Close()
-	log.DefaultLogger.Warn("CheckHealth - db closed")
+	if status != backend.HealthStatusOk {
+		return &backend.CheckHealthResult{
+			Status:  status,
+			Message: message,
+		}
+	}
+
+	//Optionally run the configured representative query through the full scan-and-frame path.
+	if instSetting.deepHealthCheck {
+		deepMessage, err := deepHealthCheck(ctx, &db.DB, instSetting.deepHealthCheckQuery)
+		if err != nil {
+			log.DefaultLogger.Warn("CheckHealth - deep health check failed", "err", err)
+			status = backend.HealthStatusError
+			message = "Deep health check failed: " + withDiagnostics(err).Error()
+		} else {
+			message = message + "; " + deepMessage
+		}
+	}
+
+	//Run the configured named checks, their results are returned as details for a checklist.
+	var details []byte
+	if len(instSetting.healthChecks) > 0 {
+		results := runHealthChecks(ctx, &db.DB, instSetting.healthChecks)
+
+		summary, criticalFailed := summarizeHealthChecks(results)
+		message = message + "; checks: " + summary
+		if criticalFailed {
+			status = backend.HealthStatusError
+		}
+
+		details, err = json.Marshal(map[string]interface{}{"checks": results})
+		if err != nil {
+			log.DefaultLogger.Warn("CheckHealth - failed marshaling check results", "err", err)
+		}
+	}
 
 	return &backend.CheckHealthResult{
-		Status:  status,
-		Message: message,
-	}, nil
+		Status:      status,
+		Message:     message,
+		JSONDetails: details,
+	}
 
 }
 
+// deepHealthCheckRows is the row limit applied to the representative query of a deep health check.
+const deepHealthCheckRows = 10
+
+// deepHealthCheckRange is the time range the macros of the representative query expand to,
+// the default range of a dashboard.
+const deepHealthCheckRange = 6 * time.Hour
+
+// deepHealthCheck runs the representative query with a tight row limit, detects the
+// types of its columns and builds a frame from the result, the same way query() does.
+// Its macros are expanded for the last deepHealthCheckRange.
+func deepHealthCheck(ctx context.Context, db *sql.DB, queryText string) (string, error) {
+	if strings.TrimSpace(queryText) == "" {
+		return "", fmt.Errorf("no representative query configured")
+	}
+
+	now := time.Now()
+	queryText, err := expandMacros(queryText, backend.DataQuery{
+		TimeRange: backend.TimeRange{From: now.Add(-deepHealthCheckRange), To: now},
+		Interval:  time.Minute,
+	})
+	if err != nil {
+		return "", err
+	}
+	queryText, _ = injectRowLimit(queryText, deepHealthCheckRows)
+
+	rows, err := db.QueryContext(ctx, queryText)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return "", fmt.Errorf("failed to get rows.ColumnTypes(): %w", err)
+	}
+
+	typeNames := make([]string, len(colTypes))
+	for i, ct := range colTypes {
+		typeNames[i] = ct.Name() + " " + ct.DatabaseTypeName()
+	}
+
+	frame, _, err := frameFromRows(rows, scanOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	rowCount, err := frame.RowLen()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("representative query returned %d rows (%s)", rowCount, strings.Join(typeNames, ", ")), nil
+}
+
+// defaultHealthCheckQuery is the probe of the health check, unless the datasource sets its own.
+const defaultHealthCheckQuery = "select current timestamp from sysibm.sysdummy1"
+
+// defaultMaxRows applies when the datasource doesn't set a row cap.
+const defaultMaxRows = 1000000
+
+// defaultHealthCheckTimeout applies when the datasource doesn't set a health check timeout.
+const defaultHealthCheckTimeout = 10 * time.Second
+
+// defaultBusyTimeout applies when the datasource doesn't set how long a query waits for a
+// free slot in the pool window, before it fails as too busy.
+const defaultBusyTimeout = 5 * time.Second
+
+// defaultQueryTimeout applies when the datasource doesn't set a query timeout.
+const defaultQueryTimeout = 30 * time.Second
+
 type instanceSettings struct {
-	pool   db2.Pool
-	constr string
-	name   string
+	//The pool and its handles are swapped when the pool is reloaded, access them through open().
+	//The other fields are set once by newDataSourceInstance and only read after, the caches
+	//and the limiter guard their own state, so requests can share the instance.
+	mu       sync.RWMutex
+	pool     *db2.Pool
+	poolKey  string   // Set when the pool is shared through the registry.
+	db       *db2.DBP // Long-lived handle, its sql.DB pools the connections itself.
+	replica  *db2.DBP // Handle to the read-only replica, nil when there is none.
+	disposed bool     // Set by Dispose, a disposed instance can't be reloaded.
+
+	name                 string
+	healthCheckQuery     string
+	healthCheckTimeout   time.Duration
+	deepHealthCheck      bool
+	deepHealthCheckQuery string
+	limiter              *windowedLimiter
+	busyTimeout          time.Duration
+	queryTimeout         time.Duration
+	allowExec            bool
+	readOnly             bool
+	fieldNameCase        string
+	autoLimit            int64
+	maxRows              int64
+	unitBySuffix         map[string]string
+	schemaCache          *ttlCache // Result columns of described queries, keyed by their SQL.
+	catalogCache         *ttlCache // Schema, table and column listings, keyed by the listing and its parameters.
+	healthChecks         []healthCheck
+	retry                retryPolicy
+	replicaStatus        *replicaStatus
 }
 
 type myDataSourceOptions struct {
-	Host     string
-	Port     string
-	Database string
-	User     string
+	Host                 string
+	Port                 string
+	Database             string
+	User                 string
+	Schema               string // Default schema of unqualified table names, the user's when empty.
+	HealthCheckQuery     string // Probe of the health check, for instances that restrict SYSIBM.
+	HealthCheckTimeout   int64  // Seconds the health check may take, 0 uses the default.
+	DeepHealthCheck      bool
+	DeepHealthCheckQuery string
+	HealthChecks         []healthCheck
+	PoolWindows          []poolWindow
+	PoolSize             int   // Also the default concurrency limit, 0 uses the default.
+	ConnMaxLifetime      int64 // Seconds, 0 uses the default.
+	MaxIdleConns         int
+	BusyTimeout          int64 // Milliseconds a query waits for a free slot, 0 uses the default.
+	QueryTimeout         int64 // Seconds a query may run, 0 uses the default.
+	ConnectTimeout       int   // Seconds to wait for a connection, 0 keeps the driver's default.
+	MaxRetries           int   // Retries of a query that failed on a connection error, 0 doesn't retry.
+	RetryBackoff         int64 // Milliseconds before the first retry, doubled for each next one.
+	AllowExec            bool
+	ReadOnly             bool // Only statements that read data can run, overrides AllowExec.
+	FieldNameCase        string
+	AutoLimit            int64
+	MaxRows              int64 // Hard cap on the rows read of any query, 0 uses the default.
+
+	//Display units for columns by name suffix, e.g. "_bytes": "bytes".
+	UnitBySuffix map[string]string
+
+	StatementConcentrator bool
+
+	//Rows fetched per round trip, 0 keeps the driver's default.
+	FetchSize int
+
+	//"password" (the default) or "kerberos", which authenticates with the ticket of the Grafana process.
+	Authentication string
+
+	//Encrypted connections, with the path of the server's certificate file.
+	SSL                  bool
+	SSLServerCertificate string
+
+	//Comma separated schemas unqualified procedures and functions are resolved in.
+	CurrentPath string
+
+	//Advanced KEY=VALUE;... attributes, overriding the structured settings above.
+	ConnectionString string
+
+	//Share the pool with the other datasources that have the same connection settings.
+	SharedPool bool
+
+	//Seconds the schema, table and column listings of the query editor are cached, 0 uses the default.
+	CatalogCacheTTL int64
+
+	//Readable HADR standby, queries are routed to it when it is set.
+	SecondaryHost string
+	SecondaryPort string
 }
 
 //InstanceFactoryFunc implementation.
 func newDataSourceInstance(setting backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
-	log.DefaultLogger.Warn("newDataSourceInstance()", "data", setting.JSONData)
-
-	// Initialize the Db2 connection pool.
-	pl := db2.Pconnect("PoolSize=30")
+	log.DefaultLogger.Debug("newDataSourceInstance()", "data", setting.JSONData)
 
 	// Unload the unsecured JSON data in a myDataSourceOptions struct.
 	var dso myDataSourceOptions
 
 	err := json.Unmarshal(setting.JSONData, &dso)
 	if err != nil {
-		log.DefaultLogger.Warn("error marshaling", "err", err)
+		log.DefaultLogger.Error("error marshaling", "err", err)
 		return nil, err
 	}
 
-	//Fetch the password from the secured JSON conainer.
-	password, _ := setting.DecryptedSecureJSONData["password"]
+	poolCfg := poolConfig{
+		size:            defaultPoolSize,
+		connMaxLifetime: defaultConnMaxLifetime,
+		maxIdleConns:    dso.MaxIdleConns,
+	}
+	if dso.PoolSize > 0 {
+		poolCfg.size = dso.PoolSize
+	}
+	if dso.ConnMaxLifetime > 0 {
+		poolCfg.connMaxLifetime = time.Duration(dso.ConnMaxLifetime) * time.Second
+	}
 
-	constr := fmt.Sprintf("HOSTNAME=%s;PORT=%s;DATABASE=%s;UID=%s;PWD=%s", dso.Host, dso.Port, dso.Database, dso.User, password)
+	//The concurrency limit can be lowered for time-of-day windows, e.g. during business hours.
+	limiter, err := newWindowedLimiter(poolCfg.size, dso.PoolWindows)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validFieldNameCase(dso.FieldNameCase)
+	if err != nil {
+		return nil, err
+	}
+
+	busyTimeout := defaultBusyTimeout
+	if dso.BusyTimeout > 0 {
+		busyTimeout = time.Duration(dso.BusyTimeout) * time.Millisecond
+	}
+
+	queryTimeout := defaultQueryTimeout
+	if dso.QueryTimeout > 0 {
+		queryTimeout = time.Duration(dso.QueryTimeout) * time.Second
+	}
+
+	maxRows := int64(defaultMaxRows)
+	if dso.MaxRows > 0 {
+		maxRows = dso.MaxRows
+	}
+
+	catalogCacheTTL := defaultCatalogCacheTTL
+	if dso.CatalogCacheTTL > 0 {
+		catalogCacheTTL = time.Duration(dso.CatalogCacheTTL) * time.Second
+	}
+
+	retry := retryPolicy{
+		maxRetries: dso.MaxRetries,
+		backoff:    defaultRetryBackoff,
+	}
+	if dso.RetryBackoff > 0 {
+		retry.backoff = time.Duration(dso.RetryBackoff) * time.Millisecond
+	}
+
+	healthCheckQuery := strings.TrimSpace(dso.HealthCheckQuery)
+	if healthCheckQuery == "" {
+		healthCheckQuery = defaultHealthCheckQuery
+	}
+
+	healthCheckTimeout := defaultHealthCheckTimeout
+	if dso.HealthCheckTimeout > 0 {
+		healthCheckTimeout = time.Duration(dso.HealthCheckTimeout) * time.Second
+	}
+
+	err = validAuthentication(dso.Authentication)
+	if err != nil {
+		return nil, err
+	}
+
+	//Fetch the password from the secured JSON conainer. It's missing when it was never set,
+	//or when Grafana couldn't decrypt it. Kerberos doesn't use one.
+	password, ok := setting.DecryptedSecureJSONData["password"]
+	if !ok && dso.Authentication != authenticationKerberos {
+		return nil, fmt.Errorf("no password for %s, set it or check that Grafana can decrypt it", setting.Name)
+	}
+
+	constr, err := connectionString(dso.Host, dso.Port, dso, password)
+	if err != nil {
+		return nil, err
+	}
+
+	var replicaConstr string
+	if dso.SecondaryHost != "" {
+		secondaryPort := dso.SecondaryPort
+		if secondaryPort == "" {
+			secondaryPort = dso.Port
+		}
+		replicaConstr, err = connectionString(dso.SecondaryHost, secondaryPort, dso, password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Initialize the Db2 connection pool. Identical datasources can share one pool,
+	// keyed by a fingerprint of their connection strings.
+	var pl *db2.Pool
+	var poolKey string
+	if dso.SharedPool {
+		poolKey = poolFingerprint(constr, replicaConstr, poolCfg.String())
+		pl = sharedPools.acquire(poolKey, poolCfg)
+	} else {
+		pl = poolCfg.newPool()
+	}
+
+	//The handles are opened once and reused by every request, until the instance is disposed.
+	db := openHandle(pl, poolKey, constr, poolCfg)
+	if db == nil {
+		releasePool(pl, poolKey)
+		return nil, fmt.Errorf("failed opening a Db2 handle for %s", setting.Name)
+	}
+
+	var replica *db2.DBP
+	if replicaConstr != "" {
+		replica = openHandle(pl, poolKey, replicaConstr, poolCfg)
+		if replica == nil {
+			releasePool(pl, poolKey, db)
+			return nil, fmt.Errorf("failed opening a Db2 handle to the replica for %s", setting.Name)
+		}
+	}
 
 	return &instanceSettings{
-		pool:   *pl,
-		constr: constr,
-		name:   setting.Name,
+		pool:                 pl,
+		poolKey:              poolKey,
+		db:                   db,
+		replica:              replica,
+		replicaStatus:        newReplicaStatus(replicaBackoff),
+		name:                 setting.Name,
+		healthCheckQuery:     healthCheckQuery,
+		healthCheckTimeout:   healthCheckTimeout,
+		deepHealthCheck:      dso.DeepHealthCheck,
+		deepHealthCheckQuery: dso.DeepHealthCheckQuery,
+		limiter:              limiter,
+		busyTimeout:          busyTimeout,
+		queryTimeout:         queryTimeout,
+		allowExec:            dso.AllowExec,
+		readOnly:             dso.ReadOnly,
+		fieldNameCase:        dso.FieldNameCase,
+		autoLimit:            dso.AutoLimit,
+		maxRows:              maxRows,
+		unitBySuffix:         dso.UnitBySuffix,
+		schemaCache:          newTTLCache(schemaCacheTTL),
+		catalogCache:         newTTLCache(catalogCacheTTL),
+		healthChecks:         dso.HealthChecks,
+		retry:                retry,
 	}, nil
 }
 
+// openHandle opens a handle for constr from the pool, or from the registry when the pool is shared.
+func openHandle(pool *db2.Pool, poolKey, constr string, config poolConfig) *db2.DBP {
+	if poolKey != "" {
+		return sharedPools.open(poolKey, constr)
+	}
+	return config.open(pool, constr)
+}
+
+// open returns the instance's handle. It must not be closed, it's reused by every request.
+func (s *instanceSettings) open() *db2.DBP {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.db
+}
+
+// openReplica returns the handle to the read-only replica, or nil when there is none.
+func (s *instanceSettings) openReplica() *db2.DBP {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.replica
+}
+
+// reload replaces the instance's pool with a new one built from setting, which holds
+// freshly decrypted credentials, e.g. after the password was rotated.
+func (s *instanceSettings) reload(setting backend.DataSourceInstanceSettings) error {
+	instance, err := newDataSourceInstance(setting)
+	if err != nil {
+		return err
+	}
+	fresh := instance.(*instanceSettings)
+
+	s.mu.Lock()
+	//A reload racing with Dispose would otherwise leave the fresh pool open for good.
+	if s.disposed {
+		s.mu.Unlock()
+		releasePool(fresh.pool, fresh.poolKey, fresh.db, fresh.replica)
+		return fmt.Errorf("the datasource %s was disposed", s.name)
+	}
+	old, oldKey, oldDB, oldReplica := s.pool, s.poolKey, s.db, s.replica
+	s.pool = fresh.pool
+	s.poolKey = fresh.poolKey
+	s.db = fresh.db
+	s.replica = fresh.replica
+	s.mu.Unlock()
+
+	//Connections of the old pool still use the old credentials.
+	releasePool(old, oldKey, oldDB, oldReplica)
+
+	return nil
+}
+
 func (s *instanceSettings) Dispose() {
 	// Called before creatinga a new instance to allow plugin authors
 	// to cleanup.
+
+	//Taking the pool makes a second Dispose a no-op. The handles are kept, a request still
+	//running on them gets a closed database error rather than a nil handle.
+	s.mu.Lock()
+	pool, poolKey, db, replica := s.pool, s.poolKey, s.db, s.replica
+	s.pool = nil
+	s.disposed = true
+	s.mu.Unlock()
+
+	log.DefaultLogger.Debug("Dispose() - " + s.name)
+
+	//Closes the handles, a shared pool is only released by the last datasource using it.
+	releasePool(pool, poolKey, db, replica)
+}
+
+// releasePool closes the connections of a pool and the handles opened from it, or drops
+// the reference to it when it is shared.
+func releasePool(pool *db2.Pool, poolKey string, handles ...*db2.DBP) {
+	if pool == nil {
+		return
+	}
+
+	if poolKey != "" {
+		sharedPools.release(poolKey)
+		return
+	}
+
+	closePool(pool, handles...)
 }
@@ -0,0 +1,581 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
+)
+
+// testSettings returns the settings of a datasource connecting to host.
+func testSettings(t *testing.T, id int64, host string, options map[string]interface{}) backend.DataSourceInstanceSettings {
+	jsonData := map[string]interface{}{
+		"Host":     host,
+		"Port":     "50000",
+		"Database": "SAMPLE",
+		"User":     "db2inst1",
+	}
+	for key, value := range options {
+		jsonData[key] = value
+	}
+
+	raw, err := json.Marshal(jsonData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return backend.DataSourceInstanceSettings{
+		ID:                      id,
+		Name:                    fmt.Sprintf("db2-%d", id),
+		JSONData:                raw,
+		DecryptedSecureJSONData: map[string]string{"password": "secret"},
+	}
+}
+
+// TestConcurrentQueries fires queries and health checks at datasources while their pools
+// are reloaded, run it with -race.
+func TestConcurrentQueries(t *testing.T) {
+	td := &Db2Datasource{im: datasource.NewInstanceManager(newDataSourceInstance)}
+
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+	}{
+		{name: "own pool"},
+		{name: "shared pool", options: map[string]interface{}{"SharedPool": true}},
+		{name: "replica", options: map[string]interface{}{"SecondaryHost": "replica.example.com"}},
+	}
+
+	var wg sync.WaitGroup
+	for i, tt := range tests {
+		settings := testSettings(t, int64(i+1), "db2.example.com", tt.options)
+		pluginContext := backend.PluginContext{DataSourceInstanceSettings: &settings}
+
+		instance, err := td.im.Get(pluginContext)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		instSetting := instance.(*instanceSettings)
+
+		for j := 0; j < 5; j++ {
+			wg.Add(3)
+			go func() {
+				defer wg.Done()
+				_, err := td.QueryData(context.Background(), &backend.QueryDataRequest{
+					PluginContext: pluginContext,
+					Queries: []backend.DataQuery{
+						{RefID: "A", JSON: []byte(`{"queryText": "SELECT 1 FROM SYSIBM.SYSDUMMY1"}`)},
+						{RefID: "B", JSON: []byte(`{"queryText": "SELECT 2 FROM SYSIBM.SYSDUMMY1"}`)},
+					},
+				})
+				if err != nil {
+					t.Errorf("QueryData() error = %v", err)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				_, err := td.CheckHealth(context.Background(), &backend.CheckHealthRequest{PluginContext: pluginContext})
+				if err != nil {
+					t.Errorf("CheckHealth() error = %v", err)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				if err := instSetting.reload(settings); err != nil {
+					t.Errorf("reload() error = %v", err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+func TestInvalidSettings(t *testing.T) {
+	td := &Db2Datasource{im: datasource.NewInstanceManager(newDataSourceInstance)}
+
+	tests := []struct {
+		name        string
+		host        string
+		password    bool
+		wantMessage string
+	}{
+		{name: "missing host", password: true, wantMessage: "missing connection settings: host"},
+		{name: "missing password", host: "db2.example.com", wantMessage: "no password for db2-201, set it or check that Grafana can decrypt it"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := testSettings(t, int64(200+i), tt.host, nil)
+			if !tt.password {
+				settings.DecryptedSecureJSONData = nil
+			}
+			pluginContext := backend.PluginContext{DataSourceInstanceSettings: &settings}
+
+			result, err := td.CheckHealth(context.Background(), &backend.CheckHealthRequest{PluginContext: pluginContext})
+			if err != nil {
+				t.Fatalf("CheckHealth() error = %v", err)
+			}
+			if result.Status != backend.HealthStatusError || result.Message != tt.wantMessage {
+				t.Errorf("CheckHealth() = %s %q, want %s %q", result.Status, result.Message, backend.HealthStatusError, tt.wantMessage)
+			}
+
+			_, err = td.QueryData(context.Background(), &backend.QueryDataRequest{PluginContext: pluginContext})
+			if err == nil || err.Error() != tt.wantMessage {
+				t.Errorf("QueryData() error = %v, want %q", err, tt.wantMessage)
+			}
+		})
+	}
+}
+
+// fakeColumn describes a result column the way the driver reports it.
+type fakeColumn struct {
+	name     string
+	dbType   string
+	scanType reflect.Type
+}
+
+// fakeResult is the result of every query on a fakeConnector, the rows are followed by err.
+// The statements run are recorded, those in failOn fail with their error instead. Executed
+// statements affect rowsAffected rows and have their arguments recorded.
+type fakeResult struct {
+	columns      []fakeColumn
+	rows         [][]driver.Value
+	err          error
+	failOn       map[string]error
+	statements   []string
+	rowsAffected int64
+	execArgs     [][]driver.Value
+}
+
+type fakeConnector struct{ result *fakeResult }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn(c), nil }
+func (c fakeConnector) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ result *fakeResult }
+
+func (c fakeConn) Prepare(statement string) (driver.Stmt, error) {
+	c.result.statements = append(c.result.statements, statement)
+	if err := c.result.failOn[statement]; err != nil {
+		return nil, err
+	}
+	return fakeStmt(c), nil
+}
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type fakeStmt struct{ result *fakeResult }
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.result.execArgs = append(s.result.execArgs, args)
+	return driver.RowsAffected(s.result.rowsAffected), nil
+}
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	return &fakeRows{result: s.result}, nil
+}
+
+type fakeRows struct {
+	result *fakeResult
+	next   int
+}
+
+func (r *fakeRows) Columns() []string {
+	names := make([]string, len(r.result.columns))
+	for i, column := range r.result.columns {
+		names[i] = column.name
+	}
+	return names
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.rows) {
+		if r.result.err != nil {
+			return r.result.err
+		}
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.next])
+	r.next++
+	return nil
+}
+
+func (r *fakeRows) ColumnTypeDatabaseTypeName(i int) string { return r.result.columns[i].dbType }
+
+func (r *fakeRows) ColumnTypeScanType(i int) reflect.Type {
+	if r.result.columns[i].scanType == nil {
+		return reflect.TypeOf(new(interface{})).Elem()
+	}
+	return r.result.columns[i].scanType
+}
+
+// openFake returns a handle on which every query returns result.
+func openFake(t *testing.T, result *fakeResult) *sql.DB {
+	db := sql.OpenDB(fakeConnector{result: result})
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// queryFake returns the rows of result, as a Db2 query would.
+func queryFake(t *testing.T, result *fakeResult) *sql.Rows {
+	rows, err := openFake(t, result).Query("SELECT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rows.Close() })
+	return rows
+}
+
+func TestFrameFromRowsBadTimeValues(t *testing.T) {
+	result := &fakeResult{
+		columns: []fakeColumn{
+			{name: "ID", dbType: "INTEGER"},
+			{name: "CREATED", dbType: "TIMESTAMP"},
+		},
+		rows: [][]driver.Value{
+			{int64(1), time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)},
+			{int64(2), "not a time"},
+			{int64(3), nil},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		opts     scanOptions
+		wantRows int
+		wantErr  bool
+	}{
+		{name: "fails by default", wantErr: true},
+		{name: "skipped with skipBadTimeRows", opts: scanOptions{skipBadTimeRows: true}, wantRows: 2},
+		{name: "partial with partialOnError", opts: scanOptions{partialOnError: true}, wantRows: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.noTimeColumn = true
+			frame, _, err := frameFromRows(queryFake(t, result), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("frameFromRows() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got, _ := frame.RowLen(); got != tt.wantRows {
+				t.Errorf("frameFromRows() rows = %d, want %d", got, tt.wantRows)
+			}
+		})
+	}
+}
+
+func TestFrameFromRowsIncomplete(t *testing.T) {
+	rows := [][]driver.Value{{int64(1)}, {int64(2)}, {int64(3)}}
+	tests := []struct {
+		name           string
+		readErr        error
+		opts           scanOptions
+		wantRows       int
+		wantIncomplete bool
+		wantErr        bool
+	}{
+		{name: "complete", wantRows: 3},
+		{name: "under the row cap", opts: scanOptions{maxRows: 3}, wantRows: 3},
+		{name: "cut off at the row cap", opts: scanOptions{maxRows: 2}, wantRows: 2, wantIncomplete: true},
+		{name: "read error", readErr: errors.New("connection lost"), wantErr: true},
+		{name: "partial on a read error", readErr: errors.New("connection lost"), opts: scanOptions{partialOnError: true}, wantRows: 3, wantIncomplete: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.noTimeColumn = true
+			result := &fakeResult{columns: []fakeColumn{{name: "ID", dbType: "BIGINT"}}, rows: rows, err: tt.readErr}
+
+			frame, incomplete, err := frameFromRows(queryFake(t, result), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("frameFromRows() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got, _ := frame.RowLen(); got != tt.wantRows {
+				t.Errorf("frameFromRows() rows = %d, want %d", got, tt.wantRows)
+			}
+			if (incomplete != "") != tt.wantIncomplete {
+				t.Errorf("frameFromRows() incomplete = %q, want incomplete %v", incomplete, tt.wantIncomplete)
+			}
+		})
+	}
+}
+
+// isClosed reports whether the handle was closed, rather than failing to connect.
+func isClosed(db *sql.DB) bool {
+	conn, err := db.Conn(context.Background())
+	if err == nil {
+		conn.Close()
+		return false
+	}
+	return err.Error() == "sql: database is closed"
+}
+
+func TestReload(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+	}{
+		{name: "own pools", options: map[string]interface{}{"ConnMaxLifetime": 1}},
+		{name: "shared pool", options: map[string]interface{}{"ConnMaxLifetime": 1, "SharedPool": true}},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			//Two datasources on the same connection string. A handle handed back to the driver's
+			//pool would be closed after the connection lifetime, so the checks wait for it.
+			settingsA := testSettings(t, int64(100+2*i), "db2.example.com", tt.options)
+			settingsB := testSettings(t, int64(101+2*i), "db2.example.com", tt.options)
+
+			instanceA, err := newDataSourceInstance(settingsA)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a := instanceA.(*instanceSettings)
+			defer a.Dispose()
+
+			instanceB, err := newDataSourceInstance(settingsB)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := instanceB.(*instanceSettings)
+			defer b.Dispose()
+
+			oldA, dbB := a.open(), b.open()
+			if err := a.reload(settingsA); err != nil {
+				t.Fatalf("reload() error = %v", err)
+			}
+			time.Sleep(1500 * time.Millisecond)
+
+			if isClosed(&a.open().DB) {
+				t.Errorf("the reloaded handle of A is closed")
+			}
+			if isClosed(&b.open().DB) || isClosed(&dbB.DB) {
+				t.Errorf("the handle of B was closed by reloading A")
+			}
+			//A shared pool keeps its handle, it's still used by B.
+			if oldA != dbB && !isClosed(&oldA.DB) {
+				t.Errorf("the old handle of A is still open")
+			}
+		})
+	}
+}
+
+func TestSharedPool(t *testing.T) {
+	options := map[string]interface{}{"SharedPool": true}
+
+	newInstance := func(id int64, host string) *instanceSettings {
+		instance, err := newDataSourceInstance(testSettings(t, id, host, options))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return instance.(*instanceSettings)
+	}
+	refs := func(key string) int {
+		sharedPools.mu.Lock()
+		defer sharedPools.mu.Unlock()
+		if shared, ok := sharedPools.pools[key]; ok {
+			return shared.refs
+		}
+		return 0
+	}
+
+	//Hosts of their own, the pools other tests leave open aren't shared with.
+	a := newInstance(400, "db2-shared.example.com")
+	b := newInstance(401, "db2-shared.example.com")
+	other := newInstance(402, "db2-other.example.com")
+	defer other.Dispose()
+
+	if a.pool != b.pool || a.open() != b.open() || refs(a.poolKey) != 2 {
+		t.Fatalf("identical datasources don't share one pool and handle, refs = %d", refs(a.poolKey))
+	}
+	if other.pool == a.pool || other.poolKey == a.poolKey {
+		t.Errorf("a datasource on another host shares the pool")
+	}
+
+	handle := a.open()
+	a.Dispose()
+	if refs(a.poolKey) != 1 || isClosed(&handle.DB) {
+		t.Errorf("disposing one datasource closed the shared pool, refs = %d", refs(a.poolKey))
+	}
+
+	b.Dispose()
+	if refs(b.poolKey) != 0 || !isClosed(&handle.DB) {
+		t.Errorf("disposing the last datasource left the shared pool open, refs = %d", refs(b.poolKey))
+	}
+}
+
+func TestDeepHealthCheck(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		failOn        map[string]error
+		wantStatement string // Without the expanded time filter, which depends on the current time.
+		wantMessage   string
+		wantErr       bool
+	}{
+		{
+			name:          "row limit added",
+			query:         "SELECT TS, VALUE FROM METRICS",
+			wantStatement: "SELECT TS, VALUE FROM METRICS\nFETCH FIRST 10 ROWS ONLY",
+			wantMessage:   "representative query returned 2 rows (TS TIMESTAMP, VALUE DOUBLE)",
+		},
+		{
+			name:          "macros expanded",
+			query:         "SELECT TS, VALUE FROM METRICS WHERE $__timeFilter(TS);",
+			wantStatement: "SELECT TS, VALUE FROM METRICS WHERE TS BETWEEN",
+			wantMessage:   "representative query returned 2 rows (TS TIMESTAMP, VALUE DOUBLE)",
+		},
+		{
+			name:          "own row limit kept",
+			query:         "SELECT TS, VALUE FROM METRICS FETCH FIRST 1 ROW ONLY",
+			wantStatement: "SELECT TS, VALUE FROM METRICS FETCH FIRST 1 ROW ONLY",
+			wantMessage:   "representative query returned 2 rows (TS TIMESTAMP, VALUE DOUBLE)",
+		},
+		{name: "no query", query: " ", wantErr: true},
+		{name: "unknown macro", query: "SELECT $__timeGroup(TS, 5m) FROM METRICS", wantErr: true},
+		{
+			name:    "query fails",
+			query:   "SELECT TS, VALUE FROM MISSING",
+			failOn:  map[string]error{"SELECT TS, VALUE FROM MISSING\nFETCH FIRST 10 ROWS ONLY": db2Error("42704", -204, "SQL0204N")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &fakeResult{
+				columns: []fakeColumn{{name: "TS", dbType: "TIMESTAMP"}, {name: "VALUE", dbType: "DOUBLE"}},
+				rows: [][]driver.Value{
+					{time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC), 1.5},
+					{time.Date(2021, 3, 1, 12, 1, 0, 0, time.UTC), 2.5},
+				},
+				failOn: tt.failOn,
+			}
+
+			message, err := deepHealthCheck(context.Background(), openFake(t, result), tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("deepHealthCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if message != tt.wantMessage {
+				t.Errorf("deepHealthCheck() = %q, want %q", message, tt.wantMessage)
+			}
+			if len(result.statements) != 1 || !strings.HasPrefix(result.statements[0], tt.wantStatement) {
+				t.Errorf("deepHealthCheck() ran %q, want %q", result.statements, tt.wantStatement)
+			}
+		})
+	}
+}
+
+func TestQueryRowLimit(t *testing.T) {
+	tests := []struct {
+		name          string
+		qm            queryModel
+		maxDataPoints int64
+		autoLimit     int64
+		want          int64
+	}{
+		{name: "no limits"},
+		{name: "auto limit", autoLimit: 5000, want: 5000},
+		{name: "time series by data points", maxDataPoints: 1000, want: 1000},
+		{name: "time series by the lower limit", qm: queryModel{Format: formatTimeSeries}, maxDataPoints: 1000, autoLimit: 500, want: 500},
+		{name: "time series by data points under the auto limit", maxDataPoints: 1000, autoLimit: 5000, want: 1000},
+		{name: "table", qm: queryModel{Format: formatTable}, maxDataPoints: 1000, autoLimit: 5000, want: 5000},
+		{name: "long", qm: queryModel{Format: formatLong}, maxDataPoints: 1000, autoLimit: 5000, want: 5000},
+		{name: "logs", qm: queryModel{Format: formatLogs}, maxDataPoints: 1000, want: 0},
+		{name: "split series", qm: queryModel{SplitColumn: "HOST"}, maxDataPoints: 1000, autoLimit: 5000, want: 5000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := queryRowLimit(tt.qm, tt.maxDataPoints, tt.autoLimit); got != tt.want {
+				t.Errorf("queryRowLimit() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBusyTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		want    time.Duration
+	}{
+		{name: "default", want: defaultBusyTimeout},
+		{name: "set", options: map[string]interface{}{"BusyTimeout": 250}, want: 250 * time.Millisecond},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance, err := newDataSourceInstance(testSettings(t, int64(300+i), "db2.example.com", tt.options))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer instance.(*instanceSettings).Dispose()
+
+			if got := instance.(*instanceSettings).busyTimeout; got != tt.want {
+				t.Errorf("busyTimeout = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecFrame(t *testing.T) {
+	const statement = "DELETE FROM LOGS WHERE TS < ?"
+
+	tests := []struct {
+		name         string
+		rowsAffected int64
+		failOn       map[string]error
+		wantErr      bool
+	}{
+		{name: "rows affected", rowsAffected: 3},
+		{name: "no rows affected"},
+		{name: "failed", failOn: map[string]error{statement: errors.New("SQL0551N")}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &fakeResult{rowsAffected: tt.rowsAffected, failOn: tt.failOn}
+			frame, err := execFrame(context.Background(), openFake(t, result), statement, []interface{}{"2021-03-01"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("execFrame() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if frame.Name != "response" || len(frame.Fields) != 1 || frame.Fields[0].Name != "rows_affected" {
+				t.Fatalf("execFrame() = %s, want a response frame with a rows_affected field", frame.Name)
+			}
+			if got := frame.Fields[0].At(0).(int64); got != tt.rowsAffected || frame.Rows() != 1 {
+				t.Errorf("rows_affected = %d in %d rows, want %d in 1 row", got, frame.Rows(), tt.rowsAffected)
+			}
+			if want := [][]driver.Value{{"2021-03-01"}}; !reflect.DeepEqual(result.execArgs, want) {
+				t.Errorf("args = %v, want %v", result.execArgs, want)
+			}
+		})
+	}
+}
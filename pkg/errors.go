@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	db2 "github.com/ibmdb/go_ibm_db"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// db2Diagnostic holds the details of a single Db2 diagnostic record.
+type db2Diagnostic struct {
+	SQLCode  int64
+	SQLState string
+	Message  string
+	Token    string
+}
+
+// tokenPattern matches the offending token in messages like SQL0104N
+// `An unexpected token "FORM" was found following ...`.
+var tokenPattern = regexp.MustCompile(`token "([^"]*)"`)
+
+// db2Diagnostics extracts the diagnostic records from an error returned by the driver.
+// Errors that don't come from Db2 result in a single record holding only the message.
+func db2Diagnostics(err error) []db2Diagnostic {
+	var db2Err *db2.Error
+	if !errors.As(err, &db2Err) || len(db2Err.Diag) == 0 {
+		return []db2Diagnostic{{Message: err.Error()}}
+	}
+
+	diags := make([]db2Diagnostic, 0, len(db2Err.Diag))
+	for _, rec := range db2Err.Diag {
+		diag := db2Diagnostic{
+			SQLCode:  int64(rec.NativeError),
+			SQLState: rec.State,
+			Message:  rec.Message,
+		}
+		if m := tokenPattern.FindStringSubmatch(rec.Message); m != nil {
+			diag.Token = m[1]
+		}
+		diags = append(diags, diag)
+	}
+
+	return diags
+}
+
+// errorFrame returns a frame with one row per Db2 diagnostic record of err, so panels
+// can render the details of a failed query.
+func errorFrame(err error) *data.Frame {
+	diags := db2Diagnostics(err)
+
+	sqlCodes := make([]int64, len(diags))
+	sqlStates := make([]string, len(diags))
+	messages := make([]string, len(diags))
+	tokens := make([]string, len(diags))
+
+	for i, diag := range diags {
+		sqlCodes[i] = diag.SQLCode
+		sqlStates[i] = diag.SQLState
+		messages[i] = diag.Message
+		tokens[i] = diag.Token
+	}
+
+	return data.NewFrame("error",
+		data.NewField("sqlcode", nil, sqlCodes),
+		data.NewField("sqlstate", nil, sqlStates),
+		data.NewField("message", nil, messages),
+		data.NewField("token", nil, tokens),
+	)
+}
+
+// diagnosticError prefixes the message of a Db2 error with the SQLCODE and SQLSTATE of
+// its first diagnostic record, which can be looked up in the Db2 documentation.
+type diagnosticError struct {
+	diag db2Diagnostic
+	err  error
+}
+
+func (e *diagnosticError) Error() string {
+	return fmt.Sprintf("SQLCODE=%d SQLSTATE=%s: %s", e.diag.SQLCode, e.diag.SQLState, e.err.Error())
+}
+
+func (e *diagnosticError) Unwrap() error {
+	return e.err
+}
+
+// withDiagnostics returns err with its SQLCODE and SQLSTATE in the message, errors that
+// don't come from Db2 are returned as is.
+func withDiagnostics(err error) error {
+	var db2Err *db2.Error
+	if err == nil || !errors.As(err, &db2Err) || len(db2Err.Diag) == 0 {
+		return err
+	}
+	return &diagnosticError{diag: db2Diagnostics(err)[0], err: err}
+}
+
+// securityErrorCodes are the SQLCODEs of failed logins. Their SQLSTATE is a connection
+// exception too, but trying again only repeats the failure and can lock the account.
+var securityErrorCodes = map[int64]bool{
+	-30082: true, // SQL30082N, security processing failed, e.g. a wrong user ID or password.
+	-1403:  true, // SQL1403N, the user name or password is incorrect.
+	-1404:  true, // SQL1404N, the password expired.
+	-1639:  true, // SQL1639N, the server couldn't read its security files.
+}
+
+// isConnectionError returns whether err means the database couldn't be reached, as opposed
+// to an error in the statement itself. SQLSTATE class 08 is a connection exception, unless
+// the login was refused.
+func isConnectionError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+
+	diags := db2Diagnostics(err)
+	for _, diag := range diags {
+		if securityErrorCodes[diag.SQLCode] {
+			return false
+		}
+	}
+	for _, diag := range diags {
+		if strings.HasPrefix(diag.SQLState, "08") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// timeoutError replaces the error of a query that ran into its timeout by a message saying so,
+// the context error of an abandoned query doesn't tell which timeout it was.
+func timeoutError(ctx context.Context, err error, timeout time.Duration) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("query exceeded %s timeout", timeout)
+	}
+	return err
+}
+
+// awaitDone runs op in its own goroutine and returns its error, or the error of ctx when ctx is
+// done first. go_ibm_db doesn't cancel a call that's already running, database/sql only checks
+// ctx before it, so this is what makes a deadline return on time. The abandoned statement keeps
+// running on the server, abandoned is called once it finishes to close what op left open.
+func awaitDone(ctx context.Context, op func() error, abandoned func()) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- op()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		go func() {
+			<-done
+			if abandoned != nil {
+				abandoned()
+			}
+		}()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	db2 "github.com/ibmdb/go_ibm_db"
+)
+
+// db2Error returns a driver error with a single diagnostic record.
+func db2Error(state string, code int, message string) error {
+	return &db2.Error{APIName: "SQLDriverConnect", Diag: []db2.DiagRecord{{State: state, NativeError: code, Message: message}}}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"communication error", db2Error("08001", -30081, "SQL30081N A communication error has been detected."), true},
+		{"connection lost", db2Error("08003", -900, "SQL0900N The application state is in error."), true},
+		{"bad connection", driver.ErrBadConn, true},
+		{"wrapped communication error", fmt.Errorf("query: %w", db2Error("08001", -30081, "")), true},
+		{"wrong password", db2Error("08001", -30082, "SQL30082N Security processing failed with reason \"24\" (\"USERNAME AND/OR PASSWORD INVALID\")."), false},
+		{"incorrect user", db2Error("08004", -1403, "SQL1403N The user name and/or password supplied is incorrect."), false},
+		{"expired password", db2Error("08004", -1404, "SQL1404N Password expired."), false},
+		{"server security files", db2Error("08001", -1639, "SQL1639N The database server was unable to perform authentication."), false},
+		{"syntax error", db2Error("42601", -104, "SQL0104N An unexpected token \"FORM\" was found."), false},
+		{"other error", errors.New("boom"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isConnectionError(tt.err); got != tt.want {
+				t.Errorf("isConnectionError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	tests := []struct {
+		name      string
+		errs      []error // Returned by the attempts in turn, nil after the last one.
+		wantCalls int
+		wantErr   bool
+	}{
+		{"success", nil, 1, false},
+		{"transient then success", []error{db2Error("08001", -30081, "")}, 2, false},
+		{"transient every time", []error{db2Error("08001", -30081, ""), db2Error("08001", -30081, ""), db2Error("08001", -30081, ""), db2Error("08001", -30081, "")}, 3, true},
+		{"wrong password fails fast", []error{db2Error("08001", -30082, ""), nil}, 1, true},
+		{"statement error fails fast", []error{db2Error("42601", -104, ""), nil}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := retryPolicy{maxRetries: 2}.do(context.Background(), func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+
+			if calls != tt.wantCalls {
+				t.Errorf("op called %d times, want %d", calls, tt.wantCalls)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("do() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAwaitDone(t *testing.T) {
+	failed := errors.New("failed")
+	tests := []struct {
+		name          string
+		opTime        time.Duration
+		opErr         error
+		wantErr       error
+		wantAbandoned bool
+	}{
+		{name: "finishes in time", opTime: 0},
+		{name: "fails in time", opTime: 0, opErr: failed, wantErr: failed},
+		{name: "runs past the deadline", opTime: 200 * time.Millisecond, wantErr: context.DeadlineExceeded, wantAbandoned: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			abandoned := make(chan struct{})
+			start := time.Now()
+			err := awaitDone(ctx, func() error {
+				time.Sleep(tt.opTime)
+				return tt.opErr
+			}, func() { close(abandoned) })
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("awaitDone() error = %v, want %v", err, tt.wantErr)
+			}
+			if elapsed := time.Since(start); elapsed > tt.opTime+100*time.Millisecond || (tt.wantAbandoned && elapsed >= tt.opTime) {
+				t.Errorf("awaitDone() returned after %s", elapsed)
+			}
+
+			select {
+			case <-abandoned:
+				if !tt.wantAbandoned {
+					t.Errorf("abandoned called for an op that finished in time")
+				}
+			case <-time.After(tt.opTime + 100*time.Millisecond):
+				if tt.wantAbandoned {
+					t.Errorf("abandoned not called after the op finished")
+				}
+			}
+		})
+	}
+}
+
+func TestDb2Diagnostics(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want []db2Diagnostic
+	}{
+		{
+			name: "token",
+			err:  db2Error("42601", -104, `SQL0104N An unexpected token "FORM" was found following "SELECT *".`),
+			want: []db2Diagnostic{{SQLCode: -104, SQLState: "42601", Message: `SQL0104N An unexpected token "FORM" was found following "SELECT *".`, Token: "FORM"}},
+		},
+		{
+			name: "without token",
+			err:  fmt.Errorf("query: %w", db2Error("42704", -204, "SQL0204N \"APP.ORDERS\" is an undefined name.")),
+			want: []db2Diagnostic{{SQLCode: -204, SQLState: "42704", Message: "SQL0204N \"APP.ORDERS\" is an undefined name."}},
+		},
+		{
+			name: "other error",
+			err:  errors.New("boom"),
+			want: []db2Diagnostic{{Message: "boom"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := db2Diagnostics(tt.err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("db2Diagnostics() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithDiagnostics(t *testing.T) {
+	syntaxErr := db2Error("42601", -104, "SQL0104N")
+	other := errors.New("boom")
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "db2 error", err: syntaxErr, want: "SQLCODE=-104 SQLSTATE=42601: " + syntaxErr.Error()},
+		{name: "other error", err: other, want: "boom"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := withDiagnostics(tt.err)
+			if got.Error() != tt.want {
+				t.Errorf("withDiagnostics() = %q, want %q", got, tt.want)
+			}
+			if !errors.Is(got, tt.err) {
+				t.Errorf("withDiagnostics() doesn't wrap %v", tt.err)
+			}
+		})
+	}
+
+	if withDiagnostics(nil) != nil {
+		t.Errorf("withDiagnostics(nil) != nil")
+	}
+}
+
+func TestTimeoutError(t *testing.T) {
+	expired, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	failed := errors.New("failed")
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want string
+	}{
+		{name: "no error", ctx: expired},
+		{name: "deadline exceeded", ctx: expired, err: context.DeadlineExceeded, want: "query exceeded 30s timeout"},
+		{name: "cancelled", ctx: cancelled, err: failed, want: "failed"},
+		{name: "still running", ctx: context.Background(), err: failed, want: "failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := timeoutError(tt.ctx, tt.err, 30*time.Second)
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("timeoutError() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorFrame(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want [][]interface{} // sqlcode, sqlstate, message and token per row.
+	}{
+		{
+			name: "diagnostic records",
+			err: &db2.Error{APIName: "SQLExecute", Diag: []db2.DiagRecord{
+				{State: "42601", NativeError: -104, Message: `SQL0104N An unexpected token "FORM" was found.`},
+				{State: "01000", NativeError: 0, Message: "[IBM][CLI Driver] CLI0001W"},
+			}},
+			want: [][]interface{}{
+				{int64(-104), "42601", `SQL0104N An unexpected token "FORM" was found.`, "FORM"},
+				{int64(0), "01000", "[IBM][CLI Driver] CLI0001W", ""},
+			},
+		},
+		{
+			name: "other error",
+			err:  errors.New("boom"),
+			want: [][]interface{}{{int64(0), "", "boom", ""}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := errorFrame(tt.err)
+
+			var names []string
+			for _, field := range frame.Fields {
+				names = append(names, field.Name)
+			}
+			if want := []string{"sqlcode", "sqlstate", "message", "token"}; frame.Name != "error" || !reflect.DeepEqual(names, want) {
+				t.Fatalf("errorFrame() = frame %q with fields %v, want frame \"error\" with fields %v", frame.Name, names, want)
+			}
+
+			if frame.Rows() != len(tt.want) {
+				t.Fatalf("errorFrame() rows = %d, want %d", frame.Rows(), len(tt.want))
+			}
+			for i, want := range tt.want {
+				if got := frame.RowCopy(i); !reflect.DeepEqual(got, want) {
+					t.Errorf("errorFrame() row %d = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Fill modes that can be set in the queryModel.
+const (
+	fillModeNone     = "none"
+	fillModeNull     = "null"
+	fillModePrevious = "previous"
+	fillModeLinear   = "linear"
+	fillModeZero     = "zero"
+)
+
+// maxFillRows caps the amount of rows a filled frame can grow to, so a tiny interval
+// over a large time range can't exhaust memory.
+const maxFillRows = 1000000
+
+// fillSource tells where a row of a filled frame comes from. A row of the source frame has
+// its index in row, an inserted row has row -1 and the source rows around the gap in prev
+// and next, -1 when the gap is at the start or the end of the time range.
+type fillSource struct {
+	row, prev, next int
+}
+
+// timeAt returns the time of row i of a time or nullable time field, false for a null.
+func timeAt(field *data.Field, i int) (time.Time, bool) {
+	switch v := field.At(i).(type) {
+	case time.Time:
+		return v, true
+	case *time.Time:
+		if v != nil {
+			return *v, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// fillFrame inserts the missing time buckets, spaced by interval, into a frame that is
+// ordered by its time field, from the start to the end of timeRange. The buckets are
+// aligned with the first row, or with the interval when there are no rows. The inserted
+// rows get their values according to mode: "null" leaves them empty, "previous" carries
+// the last observation forward, "linear" interpolates numeric fields between the
+// surrounding observations and "zero" sets numeric fields to 0. Value fields become
+// nullable, numeric fields become float64 in linear and zero mode. Rows with a null time
+// are kept where they are, without buckets around them.
+func fillFrame(frame *data.Frame, timeRange backend.TimeRange, interval time.Duration, mode string) (*data.Frame, error) {
+	switch mode {
+	case "", fillModeNone:
+		return frame, nil
+	case fillModeNull, fillModePrevious, fillModeLinear, fillModeZero:
+	default:
+		return nil, fmt.Errorf("unknown fill mode %q", mode)
+	}
+
+	timeIndices := frame.TypeIndices(data.FieldTypeTime, data.FieldTypeNullableTime)
+	if interval <= 0 || len(timeIndices) == 0 {
+		return frame, nil
+	}
+	timeIdx := timeIndices[0]
+	timeField := frame.Fields[timeIdx]
+
+	var times []time.Time
+	var sources []fillSource
+	insert := func(t time.Time, prev, next int) error {
+		times = append(times, t)
+		sources = append(sources, fillSource{row: -1, prev: prev, next: next})
+		if len(times) > maxFillRows {
+			return fmt.Errorf("filling the time series would exceed %d rows", maxFillRows)
+		}
+		return nil
+	}
+
+	//Without a time range, e.g. for a health check, only the gaps between the rows are filled.
+	ranged := !timeRange.From.IsZero() && timeRange.To.After(timeRange.From)
+
+	//The buckets before the first row, back to the start of the time range.
+	first := -1
+	for j := 0; j < timeField.Len(); j++ {
+		if _, ok := timeAt(timeField, j); ok {
+			first = j
+			break
+		}
+	}
+	anchor := timeRange.From.Truncate(interval)
+	if anchor.Before(timeRange.From) {
+		anchor = anchor.Add(interval)
+	}
+	if first >= 0 {
+		anchor, _ = timeAt(timeField, first)
+	}
+	var leading []time.Time
+	for t := anchor.Add(-interval); ranged && !t.Before(timeRange.From); t = t.Add(-interval) {
+		leading = append(leading, t)
+	}
+	for i := len(leading) - 1; i >= 0; i-- {
+		if err := insert(leading[i], -1, first); err != nil {
+			return nil, err
+		}
+	}
+
+	//The rows, with the buckets missing between them.
+	prev := -1
+	var prevTime time.Time
+	for j := 0; j < timeField.Len(); j++ {
+		cur, ok := timeAt(timeField, j)
+		if ok && prev >= 0 {
+			for t := prevTime.Add(interval); cur.Sub(t) >= interval/2; t = t.Add(interval) {
+				if err := insert(t, prev, j); err != nil {
+					return nil, err
+				}
+			}
+		}
+		times = append(times, cur)
+		sources = append(sources, fillSource{row: j, prev: -1, next: -1})
+		if ok {
+			prev, prevTime = j, cur
+		}
+	}
+
+	//The buckets after the last row, up to the end of the time range. Rows before the time
+	//range, from a query without a time filter, don't add the buckets up to its start.
+	start := anchor
+	if first >= 0 {
+		start = prevTime.Add(interval)
+	}
+	if start.Before(timeRange.From) {
+		start = start.Add((timeRange.From.Sub(start) + interval - 1) / interval * interval)
+	}
+	for t := start; ranged && !t.After(timeRange.To); t = t.Add(interval) {
+		if err := insert(t, prev, -1); err != nil {
+			return nil, err
+		}
+	}
+
+	filled := data.NewFrame(frame.Name)
+	filled.Meta = frame.Meta
+
+	for fi, field := range frame.Fields {
+		if fi == timeIdx {
+			filled.Fields = append(filled.Fields, filledTimeField(field, times, sources))
+			continue
+		}
+
+		linear := mode == fillModeLinear && field.Type().Numeric()
+		zero := mode == fillModeZero && field.Type().Numeric()
+
+		fieldType := field.Type().NullableType()
+		if linear || zero {
+			fieldType = data.FieldTypeNullableFloat64
+		}
+
+		out := data.NewFieldFromFieldType(fieldType, len(times))
+		out.Name = field.Name
+		out.Labels = field.Labels
+		out.Config = field.Config
+
+		for i, src := range sources {
+			switch {
+			case src.row >= 0 && (linear || zero):
+				if v, err := field.FloatAt(src.row); err == nil && !math.IsNaN(v) {
+					out.SetConcrete(i, v)
+				}
+			case src.row >= 0:
+				if v, ok := field.ConcreteAt(src.row); ok {
+					out.SetConcrete(i, v)
+				}
+			case mode == fillModePrevious:
+				if src.prev < 0 {
+					continue
+				}
+				if v, ok := field.ConcreteAt(src.prev); ok {
+					out.SetConcrete(i, v)
+				}
+			case zero:
+				out.SetConcrete(i, 0.0)
+			case linear:
+				if src.prev < 0 || src.next < 0 {
+					continue
+				}
+				if v, ok := interpolate(timeField, field, src.prev, src.next, times[i]); ok {
+					out.SetConcrete(i, v)
+				}
+			}
+		}
+
+		filled.Fields = append(filled.Fields, out)
+	}
+
+	return filled, nil
+}
+
+// filledTimeField builds the time field of a filled frame, of the same type as field. The
+// rows with a null time stay null in a nullable time field.
+func filledTimeField(field *data.Field, times []time.Time, sources []fillSource) *data.Field {
+	if field.Type() == data.FieldTypeTime {
+		return data.NewField(field.Name, field.Labels, times).SetConfig(field.Config)
+	}
+
+	values := make([]*time.Time, len(times))
+	for i := range times {
+		if sources[i].row >= 0 && field.At(sources[i].row).(*time.Time) == nil {
+			continue
+		}
+		t := times[i]
+		values[i] = &t
+	}
+	return data.NewField(field.Name, field.Labels, values).SetConfig(field.Config)
+}
+
+// interpolate returns the linearly interpolated value of field at time t, which lies
+// between the rows prev and next.
+func interpolate(timeField, field *data.Field, prev, next int, t time.Time) (float64, bool) {
+	prevValue, err := field.FloatAt(prev)
+	if err != nil || math.IsNaN(prevValue) {
+		return 0, false
+	}
+	nextValue, err := field.FloatAt(next)
+	if err != nil || math.IsNaN(nextValue) {
+		return 0, false
+	}
+
+	prevTime, _ := timeAt(timeField, prev)
+	nextTime, _ := timeAt(timeField, next)
+	span := nextTime.Sub(prevTime)
+	if span <= 0 {
+		return prevValue, true
+	}
+
+	ratio := float64(t.Sub(prevTime)) / float64(span)
+	return prevValue + (nextValue-prevValue)*ratio, true
+}
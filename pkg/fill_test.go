@@ -0,0 +1,147 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestFillFrame(t *testing.T) {
+	base := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	minute := func(m int) time.Time { return base.Add(time.Duration(m) * time.Minute) }
+	float := func(f float64) *float64 { return &f }
+	series := func(minutes []int, values []*float64) *data.Frame {
+		times := make([]time.Time, len(minutes))
+		for i, m := range minutes {
+			times[i] = minute(m)
+		}
+		return data.NewFrame("response", data.NewField("time", nil, times), data.NewField("value", nil, values))
+	}
+	fullRange := backend.TimeRange{From: minute(0), To: minute(5)}
+
+	tests := []struct {
+		name        string
+		frame       *data.Frame
+		timeRange   backend.TimeRange
+		mode        string
+		wantMinutes []int
+		wantValues  []interface{}
+		wantErr     bool
+	}{
+		{
+			name:        "none",
+			frame:       series([]int{1, 3}, []*float64{float(1), float(3)}),
+			timeRange:   fullRange,
+			mode:        fillModeNone,
+			wantMinutes: []int{1, 3},
+			wantValues:  []interface{}{1.0, 3.0},
+		},
+		{
+			name:        "gaps without a time range",
+			frame:       series([]int{1, 3}, []*float64{float(1), float(3)}),
+			mode:        fillModeNull,
+			wantMinutes: []int{1, 2, 3},
+			wantValues:  []interface{}{1.0, nil, 3.0},
+		},
+		{
+			name:        "null over the time range",
+			frame:       series([]int{2, 3}, []*float64{float(2), float(3)}),
+			timeRange:   fullRange,
+			mode:        fillModeNull,
+			wantMinutes: []int{0, 1, 2, 3, 4, 5},
+			wantValues:  []interface{}{nil, nil, 2.0, 3.0, nil, nil},
+		},
+		{
+			name:        "previous",
+			frame:       series([]int{2, 3}, []*float64{float(2), float(3)}),
+			timeRange:   fullRange,
+			mode:        fillModePrevious,
+			wantMinutes: []int{0, 1, 2, 3, 4, 5},
+			wantValues:  []interface{}{nil, nil, 2.0, 3.0, 3.0, 3.0},
+		},
+		{
+			name:        "zero",
+			frame:       series([]int{2, 3}, []*float64{float(2), float(3)}),
+			timeRange:   fullRange,
+			mode:        fillModeZero,
+			wantMinutes: []int{0, 1, 2, 3, 4, 5},
+			wantValues:  []interface{}{0.0, 0.0, 2.0, 3.0, 0.0, 0.0},
+		},
+		{
+			name:        "linear",
+			frame:       series([]int{1, 4}, []*float64{float(1), float(4)}),
+			timeRange:   fullRange,
+			mode:        fillModeLinear,
+			wantMinutes: []int{0, 1, 2, 3, 4, 5},
+			wantValues:  []interface{}{nil, 1.0, 2.0, 3.0, 4.0, nil},
+		},
+		{
+			name:        "no rows",
+			frame:       series(nil, nil),
+			timeRange:   backend.TimeRange{From: minute(0).Add(time.Second), To: minute(2)},
+			mode:        fillModeNull,
+			wantMinutes: []int{1, 2},
+			wantValues:  []interface{}{nil, nil},
+		},
+		{
+			name:        "rows before the time range",
+			frame:       series([]int{-60}, []*float64{float(1)}),
+			timeRange:   backend.TimeRange{From: minute(0), To: minute(2)},
+			mode:        fillModeNull,
+			wantMinutes: []int{-60, 0, 1, 2},
+			wantValues:  []interface{}{1.0, nil, nil, nil},
+		},
+		{
+			name: "nullable time",
+			frame: data.NewFrame("response",
+				data.NewField("time", nil, []*time.Time{timePtr(minute(1)), timePtr(minute(3))}),
+				data.NewField("value", nil, []*float64{float(1), float(3)})),
+			timeRange:   fullRange,
+			mode:        fillModeNull,
+			wantMinutes: []int{0, 1, 2, 3, 4, 5},
+			wantValues:  []interface{}{nil, 1.0, nil, 3.0, nil, nil},
+		},
+		{
+			name:      "unknown mode",
+			frame:     series([]int{1}, []*float64{float(1)}),
+			timeRange: fullRange,
+			mode:      "spline",
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filled, err := fillFrame(tt.frame, tt.timeRange, time.Minute, tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fillFrame() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			timeField, valueField := filled.Fields[0], filled.Fields[1]
+			if timeField.Len() != len(tt.wantMinutes) {
+				t.Fatalf("fillFrame() rows = %d, want %d", timeField.Len(), len(tt.wantMinutes))
+			}
+			for i, m := range tt.wantMinutes {
+				if got, _ := timeAt(timeField, i); !got.Equal(minute(m)) {
+					t.Errorf("time %d = %s, want %s", i, got, minute(m))
+				}
+				got, ok := valueField.ConcreteAt(i)
+				if !ok {
+					got = nil
+				}
+				if got != tt.wantValues[i] {
+					t.Errorf("value %d = %v, want %v", i, got, tt.wantValues[i])
+				}
+			}
+		})
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
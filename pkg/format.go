@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Query formats, selecting the shape of the returned frame.
+const (
+	formatDefault = ""
+	// The default, a wide series with the first column as time.
+	formatTimeSeries = "time_series"
+	// Rows of (time, metric name, value). Wide results are converted.
+	formatLong = "long"
+	// A plain table, no column is used as time.
+	formatTable = "table"
+	// Events to overlay on graphs, with time, timeEnd, text and tags columns.
+	formatAnnotations = "annotations"
+	// Log lines for the logs panel, with time, message and level columns, other columns are labels.
+	formatLogs = "logs"
+)
+
+// validFormat returns an error for an unknown query format.
+func validFormat(format string) error {
+	switch format {
+	case formatDefault, formatTimeSeries, formatLong, formatTable, formatAnnotations, formatLogs:
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// longFrame returns a frame in long format. A long frame has a time field, string fields
+// naming the series and numeric value fields, it's returned as is. A wide frame, with a
+// time field and only numeric value fields, is converted to long.
+func longFrame(frame *data.Frame) (*data.Frame, error) {
+	if len(frame.Fields) == 0 || frame.Rows() == 0 {
+		return frame, nil
+	}
+
+	switch frame.TimeSeriesSchema().Type {
+	case data.TimeSeriesTypeLong:
+		return frame, nil
+	case data.TimeSeriesTypeWide:
+		return data.WideToLong(frame)
+	default:
+		return nil, fmt.Errorf("long format needs a time column and numeric value columns, optionally with string columns naming the series")
+	}
+}
+
+// tableFrame marks the frame to be shown as a table.
+func tableFrame(frame *data.Frame) *data.Frame {
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+	frame.Meta.PreferredVisualization = data.VisTypeTable
+	return frame
+}
+
+// annotationFields are the field names Grafana reads annotations from. tags holds
+// comma separated tags.
+var annotationFields = []string{"time", "timeEnd", "text", "tags"}
+
+// annotationFrame renames the annotation columns, which Db2 returns in upper case, to
+// the field names Grafana expects. The time column is required, the others are optional.
+func annotationFrame(frame *data.Frame) (*data.Frame, error) {
+	for _, name := range annotationFields {
+		for _, field := range frame.Fields {
+			if strings.EqualFold(field.Name, name) {
+				field.Name = name
+				break
+			}
+		}
+	}
+
+	for _, field := range frame.Fields {
+		if field.Name != "time" {
+			continue
+		}
+		if field.Type() != data.FieldTypeTime && field.Type() != data.FieldTypeNullableTime {
+			return nil, fmt.Errorf("annotation column time is not a timestamp")
+		}
+		return frame, nil
+	}
+
+	return nil, fmt.Errorf("annotations need a time column")
+}
+
+// Column names of the log line and its severity, matched case-insensitively. Without a
+// message column, the first other string column is the log line.
+var (
+	logMessageColumns = []string{"message", "body", "msg", "line"}
+	logLevelColumns   = []string{"level", "severity"}
+)
+
+// logFrames turns rows of log lines into frames for the logs panel. The other columns
+// become labels of the log line, and the lines are grouped in a frame per set of labels,
+// in the order the sets first occur. Each frame gets a copy of the frame's meta.
+func logFrames(frame *data.Frame) ([]*data.Frame, error) {
+	timeIdx, messageIdx, levelIdx := -1, -1, -1
+	for i, field := range frame.Fields {
+		switch {
+		case timeIdx < 0 && (field.Type() == data.FieldTypeTime || field.Type() == data.FieldTypeNullableTime):
+			timeIdx = i
+		case messageIdx < 0 && matchesName(field.Name, logMessageColumns):
+			messageIdx = i
+		case levelIdx < 0 && matchesName(field.Name, logLevelColumns):
+			levelIdx = i
+		}
+	}
+	if messageIdx < 0 {
+		for i, field := range frame.Fields {
+			if i != levelIdx && (field.Type() == data.FieldTypeString || field.Type() == data.FieldTypeNullableString) {
+				messageIdx = i
+				break
+			}
+		}
+	}
+	if timeIdx < 0 || messageIdx < 0 {
+		return nil, fmt.Errorf("logs need a time column and a message column")
+	}
+
+	newGroup := func(labels data.Labels) *data.Frame {
+		group := data.NewFrame("",
+			data.NewFieldFromFieldType(frame.Fields[timeIdx].Type(), 0),
+			data.NewFieldFromFieldType(frame.Fields[messageIdx].Type(), 0),
+		)
+		group.Meta = copyMeta(frame.Meta)
+		group.Fields[0].Name = "time"
+		group.Fields[1].Name = "message"
+		group.Fields[1].Labels = labels
+		if levelIdx >= 0 {
+			level := data.NewFieldFromFieldType(frame.Fields[levelIdx].Type(), 0)
+			level.Name = "level"
+			group.Fields = append(group.Fields, level)
+		}
+		return group
+	}
+
+	var keys []string
+	groups := make(map[string]*data.Frame)
+	for row := 0; row < frame.Rows(); row++ {
+		labels := data.Labels{}
+		for i, field := range frame.Fields {
+			if i == timeIdx || i == messageIdx || i == levelIdx {
+				continue
+			}
+			if v, ok := field.ConcreteAt(row); ok {
+				labels[field.Name] = fmt.Sprint(v)
+			}
+		}
+
+		key := labels.String()
+		group, ok := groups[key]
+		if !ok {
+			group = newGroup(labels)
+			groups[key] = group
+			keys = append(keys, key)
+		}
+
+		values := []interface{}{frame.CopyAt(timeIdx, row), frame.CopyAt(messageIdx, row)}
+		if levelIdx >= 0 {
+			values = append(values, frame.CopyAt(levelIdx, row))
+		}
+		group.AppendRow(values...)
+	}
+
+	if len(keys) == 0 {
+		return []*data.Frame{newGroup(nil)}, nil
+	}
+
+	frames := make([]*data.Frame, len(keys))
+	for i, key := range keys {
+		frames[i] = groups[key]
+	}
+	return frames, nil
+}
+
+// logsFrame marks the frame to be shown as log lines.
+func logsFrame(frame *data.Frame) *data.Frame {
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+	frame.Meta.PreferredVisualization = data.VisTypeLogs
+	return frame
+}
+
+func matchesName(name string, names []string) bool {
+	for _, n := range names {
+		if strings.EqualFold(name, n) {
+			return true
+		}
+	}
+	return false
+}
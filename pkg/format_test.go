@@ -0,0 +1,271 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestValidFormat(t *testing.T) {
+	tests := []struct {
+		format  string
+		wantErr bool
+	}{
+		{formatDefault, false},
+		{formatTimeSeries, false},
+		{formatLong, false},
+		{formatTable, false},
+		{formatAnnotations, false},
+		{formatLogs, false},
+		{"heatmap", true},
+		{"TABLE", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			if err := validFormat(tt.format); (err != nil) != tt.wantErr {
+				t.Errorf("validFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLongFrame(t *testing.T) {
+	t0 := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	times := []time.Time{t0, t0.Add(time.Minute)}
+
+	tests := []struct {
+		name       string
+		frame      *data.Frame
+		wantFields []string
+		wantRows   int
+		wantErr    bool
+	}{
+		{
+			name:       "wide is converted",
+			frame:      data.NewFrame("", data.NewField("TIME", nil, times), data.NewField("CPU", nil, []float64{1, 2}), data.NewField("MEM", nil, []float64{3, 4})),
+			wantFields: []string{"TIME", "CPU", "MEM"},
+			wantRows:   2,
+		},
+		{
+			name:       "long is kept",
+			frame:      data.NewFrame("", data.NewField("TIME", nil, times), data.NewField("HOST", nil, []string{"a", "b"}), data.NewField("CPU", nil, []float64{1, 2})),
+			wantFields: []string{"TIME", "HOST", "CPU"},
+			wantRows:   2,
+		},
+		{
+			name:       "empty is kept",
+			frame:      data.NewFrame("", data.NewField("HOST", nil, []string{})),
+			wantFields: []string{"HOST"},
+		},
+		{
+			name:    "no time column",
+			frame:   data.NewFrame("", data.NewField("HOST", nil, []string{"a"}), data.NewField("CPU", nil, []float64{1})),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := longFrame(tt.frame)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("longFrame() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Rows() != tt.wantRows || len(got.Fields) != len(tt.wantFields) {
+				t.Fatalf("longFrame() = %d fields, %d rows, want %d fields, %d rows", len(got.Fields), got.Rows(), len(tt.wantFields), tt.wantRows)
+			}
+			for i, name := range tt.wantFields {
+				if got.Fields[i].Name != name {
+					t.Errorf("longFrame() field %d = %s, want %s", i, got.Fields[i].Name, name)
+				}
+			}
+		})
+	}
+}
+
+func TestTableFrame(t *testing.T) {
+	tests := []struct {
+		name string
+		meta *data.FrameMeta
+	}{
+		{name: "without meta"},
+		{name: "with meta", meta: &data.FrameMeta{ExecutedQueryString: "SELECT 1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := data.NewFrame("")
+			frame.Meta = tt.meta
+			if got := tableFrame(frame).Meta.PreferredVisualization; got != data.VisTypeTable {
+				t.Errorf("tableFrame() visualization = %s, want %s", got, data.VisTypeTable)
+			}
+			if tt.meta != nil && frame.Meta.ExecutedQueryString != "SELECT 1" {
+				t.Errorf("tableFrame() dropped the executed query")
+			}
+		})
+	}
+}
+
+func TestAnnotationFrame(t *testing.T) {
+	t0 := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		frame      *data.Frame
+		wantFields []string
+		wantErr    bool
+	}{
+		{
+			name: "renames the columns",
+			frame: data.NewFrame("",
+				data.NewField("TIME", nil, []time.Time{t0}),
+				data.NewField("TIMEEND", nil, []time.Time{t0}),
+				data.NewField("TEXT", nil, []string{"deploy"}),
+				data.NewField("TAGS", nil, []string{"a,b"}),
+				data.NewField("HOST", nil, []string{"db1"}),
+			),
+			wantFields: []string{"time", "timeEnd", "text", "tags", "HOST"},
+		},
+		{
+			name:       "only time",
+			frame:      data.NewFrame("", data.NewField("Time", nil, []*time.Time{&t0})),
+			wantFields: []string{"time"},
+		},
+		{
+			name:    "no time column",
+			frame:   data.NewFrame("", data.NewField("TEXT", nil, []string{"deploy"})),
+			wantErr: true,
+		},
+		{
+			name:    "time is not a timestamp",
+			frame:   data.NewFrame("", data.NewField("TIME", nil, []int64{1614600000})),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := annotationFrame(tt.frame)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("annotationFrame() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			for i, name := range tt.wantFields {
+				if got.Fields[i].Name != name {
+					t.Errorf("annotationFrame() field %d = %s, want %s", i, got.Fields[i].Name, name)
+				}
+			}
+		})
+	}
+}
+
+func TestLogFrames(t *testing.T) {
+	t0 := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	times := []time.Time{t0, t0.Add(time.Second), t0.Add(2 * time.Second)}
+
+	tests := []struct {
+		name       string
+		frame      *data.Frame
+		wantLabels []string // Per returned frame.
+		wantRows   []int
+		wantLevel  bool
+		wantErr    bool
+	}{
+		{
+			name: "grouped by the other columns",
+			frame: data.NewFrame("",
+				data.NewField("TS", nil, times),
+				data.NewField("HOST", nil, []string{"db2", "db1", "db2"}),
+				data.NewField("MSG", nil, []string{"started", "started", "stopped"}),
+				data.NewField("SEVERITY", nil, []string{"info", "info", "warn"}),
+			),
+			wantLabels: []string{"HOST=db2", "HOST=db1"},
+			wantRows:   []int{2, 1},
+			wantLevel:  true,
+		},
+		{
+			name: "first string column is the message",
+			frame: data.NewFrame("",
+				data.NewField("TS", nil, times),
+				data.NewField("LINE_TEXT", nil, []string{"a", "b", "c"}),
+			),
+			wantLabels: []string{""},
+			wantRows:   []int{3},
+		},
+		{
+			name: "no rows",
+			frame: data.NewFrame("",
+				data.NewField("TS", nil, []time.Time{}),
+				data.NewField("MESSAGE", nil, []string{}),
+			),
+			wantLabels: []string{""},
+			wantRows:   []int{0},
+		},
+		{
+			name:    "no time column",
+			frame:   data.NewFrame("", data.NewField("MESSAGE", nil, []string{"a"})),
+			wantErr: true,
+		},
+		{
+			name:    "no message column",
+			frame:   data.NewFrame("", data.NewField("TS", nil, []time.Time{t0}), data.NewField("N", nil, []int64{1})),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, err := logFrames(tt.frame)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("logFrames() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(frames) != len(tt.wantLabels) {
+				t.Fatalf("logFrames() returned %d frames, want %d", len(frames), len(tt.wantLabels))
+			}
+			for i, f := range frames {
+				if got := f.Fields[1].Labels.String(); got != tt.wantLabels[i] || f.Rows() != tt.wantRows[i] {
+					t.Errorf("frame %d = %q with %d rows, want %q with %d rows", i, got, f.Rows(), tt.wantLabels[i], tt.wantRows[i])
+				}
+				if f.Fields[0].Name != "time" || f.Fields[1].Name != "message" {
+					t.Errorf("frame %d fields = %s, %s, want time, message", i, f.Fields[0].Name, f.Fields[1].Name)
+				}
+				if hasLevel := len(f.Fields) == 3 && f.Fields[2].Name == "level"; hasLevel != tt.wantLevel {
+					t.Errorf("frame %d has level = %v, want %v", i, hasLevel, tt.wantLevel)
+				}
+			}
+		})
+	}
+}
+
+func TestLogFramesMeta(t *testing.T) {
+	t0 := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	frame := data.NewFrame("",
+		data.NewField("TS", nil, []time.Time{t0, t0}),
+		data.NewField("HOST", nil, []string{"db1", "db2"}),
+		data.NewField("MESSAGE", nil, []string{"started", "started"}),
+	)
+	addNotice(frame, data.NoticeSeverityWarning, "cut 1 message")
+
+	frames, err := logFrames(frame)
+	if err != nil {
+		t.Fatalf("logFrames() error = %v", err)
+	}
+	for i, f := range frames {
+		f = logsFrame(f)
+		if len(f.Meta.Notices) != 1 || f.Meta.PreferredVisualization != data.VisTypeLogs {
+			t.Errorf("frame %d meta = %+v, want the notices of the frame shown as logs", i, f.Meta)
+		}
+	}
+	if frame.Meta.PreferredVisualization != "" {
+		t.Errorf("logsFrame() changed the meta of the source frame")
+	}
+}
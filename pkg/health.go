@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// healthCheck is a named probe query that CheckHealth runs, as configured in the datasource
+// settings, e.g. a query on a table to check schema access. A failing critical check fails
+// the health check as a whole.
+type healthCheck struct {
+	Name     string
+	Query    string
+	Critical bool
+}
+
+// healthCheckResult is the outcome of a single check, returned in the health check details
+// so the config page can show them as a checklist.
+type healthCheckResult struct {
+	Name     string `json:"name"`
+	Passed   bool   `json:"passed"`
+	Critical bool   `json:"critical"`
+	Detail   string `json:"detail"`
+}
+
+// runHealthChecks runs the checks in order. A check passes when its query runs, its detail
+// is the first value it returns or the error.
+func runHealthChecks(ctx context.Context, db *sql.DB, checks []healthCheck) []healthCheckResult {
+	results := make([]healthCheckResult, 0, len(checks))
+
+	for _, check := range checks {
+		result := healthCheckResult{Name: check.Name, Critical: check.Critical}
+
+		detail, err := runHealthCheck(ctx, db, check.Query)
+		if err != nil {
+			result.Detail = err.Error()
+		} else {
+			result.Passed = true
+			result.Detail = detail
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func runHealthCheck(ctx context.Context, db *sql.DB, queryText string) (string, error) {
+	rows, err := db.QueryContext(ctx, queryText)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+
+	if len(cols) == 0 {
+		return "no columns", nil
+	}
+
+	if !rows.Next() {
+		return "no rows", rows.Err()
+	}
+
+	//Only the first value is shown, the other columns are scanned and discarded.
+	var first sql.NullString
+	dest := make([]interface{}, len(cols))
+	dest[0] = &first
+	for i := 1; i < len(cols); i++ {
+		dest[i] = new(interface{})
+	}
+
+	err = rows.Scan(dest...)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(first.String), nil
+}
+
+// summarizeHealthChecks returns a one line summary of the results, and whether a critical
+// check failed.
+func summarizeHealthChecks(results []healthCheckResult) (string, bool) {
+	criticalFailed := false
+	parts := make([]string, len(results))
+
+	for i, result := range results {
+		if result.Passed {
+			parts[i] = result.Name + " passed"
+			continue
+		}
+		parts[i] = fmt.Sprintf("%s failed (%s)", result.Name, result.Detail)
+		if result.Critical {
+			criticalFailed = true
+		}
+	}
+
+	return strings.Join(parts, ", "), criticalFailed
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRunHealthChecks(t *testing.T) {
+	const (
+		passing = "SELECT COUNT(*) FROM APP.ORDERS"
+		failing = "SELECT 1 FROM APP.AUDIT FETCH FIRST 1 ROW ONLY"
+	)
+
+	tests := []struct {
+		name               string
+		critical           bool
+		wantSummary        string
+		wantCriticalFailed bool
+	}{
+		{name: "failing check", wantSummary: "orders passed, audit failed (SQL0551N)"},
+		{name: "failing critical check", critical: true, wantSummary: "orders passed, audit failed (SQL0551N)", wantCriticalFailed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &fakeResult{
+				columns: []fakeColumn{{name: "1"}},
+				rows:    [][]driver.Value{{int64(42)}},
+				failOn:  map[string]error{failing: errors.New("SQL0551N")},
+			}
+			checks := []healthCheck{
+				{Name: "orders", Query: passing, Critical: true},
+				{Name: "audit", Query: failing, Critical: tt.critical},
+			}
+
+			results := runHealthChecks(context.Background(), openFake(t, result), checks)
+			want := []healthCheckResult{
+				{Name: "orders", Passed: true, Critical: true, Detail: "42"},
+				{Name: "audit", Critical: tt.critical, Detail: "SQL0551N"},
+			}
+			if !reflect.DeepEqual(results, want) {
+				t.Errorf("runHealthChecks() = %+v, want %+v", results, want)
+			}
+
+			summary, criticalFailed := summarizeHealthChecks(results)
+			if summary != tt.wantSummary || criticalFailed != tt.wantCriticalFailed {
+				t.Errorf("summarizeHealthChecks() = %q, %v, want %q, %v", summary, criticalFailed, tt.wantSummary, tt.wantCriticalFailed)
+			}
+		})
+	}
+}
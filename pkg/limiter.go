@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultPoolSize is the size of the Db2 connection pool when the datasource doesn't set
+// one. The pool size is the concurrency limit outside of any configured pool window.
+const defaultPoolSize = 30
+
+// poolWindow is a time-of-day window, as configured in the datasource settings, during
+// which a different concurrency limit applies. Start and End are "HH:MM" local times,
+// a window with an End before its Start wraps around midnight.
+type poolWindow struct {
+	Start string
+	End   string
+	Limit int
+}
+
+type parsedWindow struct {
+	start time.Duration
+	end   time.Duration
+	limit int
+}
+
+// windowedLimiter limits the number of queries in flight for an instance. The limit
+// depends on the time of day through the configured windows.
+type windowedLimiter struct {
+	mu           sync.Mutex
+	windows      []parsedWindow
+	defaultLimit int
+	inFlight     int
+	released     chan struct{}
+	now          func() time.Time
+}
+
+func newWindowedLimiter(defaultLimit int, windows []poolWindow) (*windowedLimiter, error) {
+	l := &windowedLimiter{
+		defaultLimit: defaultLimit,
+		released:     make(chan struct{}),
+		now:          time.Now,
+	}
+
+	for _, w := range windows {
+		start, err := parseTimeOfDay(w.Start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pool window start: %w", err)
+		}
+		end, err := parseTimeOfDay(w.End)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pool window end: %w", err)
+		}
+		if w.Limit < 1 {
+			return nil, fmt.Errorf("invalid pool window limit %d for %s-%s", w.Limit, w.Start, w.End)
+		}
+		l.windows = append(l.windows, parsedWindow{start: start, end: end, limit: w.Limit})
+	}
+
+	return l, nil
+}
+
+// parseTimeOfDay parses a "HH:MM" time of day into the duration since midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// limit returns the concurrency limit in effect at the current time. The first
+// matching window wins.
+func (l *windowedLimiter) limit() int {
+	now := l.now()
+	sinceMidnight := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute + time.Duration(now.Second())*time.Second
+
+	for _, w := range l.windows {
+		inWindow := sinceMidnight >= w.start && sinceMidnight < w.end
+		if w.end <= w.start {
+			inWindow = sinceMidnight >= w.start || sinceMidnight < w.end
+		}
+		if inWindow {
+			return w.limit
+		}
+	}
+
+	return l.defaultLimit
+}
+
+// errBusy is returned when no query slot frees up within the busy timeout.
+var errBusy = errors.New("the datasource is too busy, try again later")
+
+// acquire waits until a query may run under the current limit, or until ctx is done.
+// With a maxWait above zero it gives up with errBusy after waiting that long.
+func (l *windowedLimiter) acquire(ctx context.Context, maxWait time.Duration) error {
+	var busy <-chan time.Time
+	if maxWait > 0 {
+		timer := time.NewTimer(maxWait)
+		defer timer.Stop()
+		busy = timer.C
+	}
+
+	for {
+		l.mu.Lock()
+		if l.inFlight < l.limit() {
+			l.inFlight++
+			l.mu.Unlock()
+			return nil
+		}
+		released := l.released
+		l.mu.Unlock()
+
+		//Wait for a release, and re-check every second in case a window boundary passed.
+		select {
+		case <-released:
+		case <-time.After(time.Second):
+		case <-busy:
+			return errBusy
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release frees the slot taken by acquire and wakes up the waiting queries.
+func (l *windowedLimiter) release() {
+	l.mu.Lock()
+	l.inFlight--
+	close(l.released)
+	l.released = make(chan struct{})
+	l.mu.Unlock()
+}
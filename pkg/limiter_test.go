@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewWindowedLimiter(t *testing.T) {
+	tests := []struct {
+		name    string
+		windows []poolWindow
+		wantErr bool
+	}{
+		{name: "no windows"},
+		{name: "valid window", windows: []poolWindow{{Start: "08:00", End: "18:00", Limit: 5}}},
+		{name: "bad start", windows: []poolWindow{{Start: "8am", End: "18:00", Limit: 5}}, wantErr: true},
+		{name: "bad end", windows: []poolWindow{{Start: "08:00", End: "24:00", Limit: 5}}, wantErr: true},
+		{name: "zero limit", windows: []poolWindow{{Start: "08:00", End: "18:00"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newWindowedLimiter(defaultPoolSize, tt.windows); (err != nil) != tt.wantErr {
+				t.Errorf("newWindowedLimiter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWindowedLimiterLimit(t *testing.T) {
+	l, err := newWindowedLimiter(30, []poolWindow{
+		{Start: "08:00", End: "18:00", Limit: 5},
+		{Start: "22:00", End: "02:00", Limit: 2},
+		{Start: "12:00", End: "13:00", Limit: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		clock string
+		want  int
+	}{
+		{"07:59:59", 30},
+		{"08:00:00", 5},
+		{"12:30:00", 5}, // The first matching window wins.
+		{"17:59:59", 5},
+		{"18:00:00", 30},
+		{"22:00:00", 2},
+		{"23:59:59", 2},
+		{"00:00:00", 2},
+		{"01:59:59", 2},
+		{"02:00:00", 30},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.clock, func(t *testing.T) {
+			clock, err := time.Parse("15:04:05", tt.clock)
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Date(2021, 3, 1, clock.Hour(), clock.Minute(), clock.Second(), 0, time.Local)
+			l.now = func() time.Time { return now }
+
+			if got := l.limit(); got != tt.want {
+				t.Errorf("limit() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWindowedLimiterAcquire(t *testing.T) {
+	tests := []struct {
+		name     string
+		inFlight int
+		maxWait  time.Duration
+		timeout  time.Duration
+		release  bool
+		wantErr  error
+	}{
+		{name: "free slot", inFlight: 1},
+		{name: "busy timeout", inFlight: 2, maxWait: 50 * time.Millisecond, wantErr: errBusy},
+		{name: "context done", inFlight: 2, timeout: 50 * time.Millisecond, wantErr: context.DeadlineExceeded},
+		{name: "slot released", inFlight: 2, maxWait: 500 * time.Millisecond, release: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := newWindowedLimiter(2, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.inFlight = tt.inFlight
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			if tt.release {
+				go func() {
+					time.Sleep(20 * time.Millisecond)
+					l.release()
+				}()
+			}
+
+			if err := l.acquire(ctx, tt.maxWait); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("acquire() error = %v, want %v", err, tt.wantErr)
+			}
+
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			want := tt.inFlight
+			if tt.wantErr == nil && !tt.release {
+				want++
+			}
+			if l.inFlight != want {
+				t.Errorf("inFlight = %d, want %d", l.inFlight, want)
+			}
+		})
+	}
+}
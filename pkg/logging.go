@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	db2 "github.com/ibmdb/go_ibm_db"
+)
+
+// maxLoggedSQLLength caps the SQL text in log lines, queries can be pages long.
+const maxLoggedSQLLength = 200
+
+// queryLogArgs returns the key/value pairs that tie a log line to the query it's about.
+// With an error, the error and its Db2 SQLCODE are included.
+func queryLogArgs(refID, queryText string, start time.Time, err error) []interface{} {
+	if len(queryText) > maxLoggedSQLLength {
+		queryText = queryText[:maxLoggedSQLLength] + "..."
+	}
+
+	args := []interface{}{
+		"refId", refID,
+		"sql", queryText,
+		"durationMs", time.Since(start).Milliseconds(),
+	}
+
+	if err != nil {
+		args = append(args, "err", err)
+
+		var db2Err *db2.Error
+		if errors.As(err, &db2Err) && len(db2Err.Diag) > 0 {
+			args = append(args, "sqlCode", db2Err.Diag[0].NativeError)
+		}
+	}
+
+	return args
+}
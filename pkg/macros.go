@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// db2TimestampLayout formats times as Db2 timestamp literals.
+const db2TimestampLayout = "2006-01-02 15:04:05"
+
+var (
+	macroPattern      = regexp.MustCompile(`\$__(\w+)\(([^)]*)\)`)
+	timeFilterPattern = regexp.MustCompile(`\$__timeFilter\(\s*(\w+)\.(\w+)\s*\)`)
+	// The interval macros are variables, they take no arguments.
+	intervalPattern = regexp.MustCompile(`\$__(interval_ms|interval)\b(\(\))?`)
+)
+
+// expandMacros replaces the Grafana macros in the query text by SQL, based on the
+// time range of the query.
+func expandMacros(sql string, query backend.DataQuery) (string, error) {
+	sql = pushDownTimeFilters(sql, query.TimeRange)
+
+	interval := macroInterval(query)
+	sql = replaceMacros(sql, intervalPattern, func(m []string) string {
+		if m[1] == "interval_ms" {
+			return strconv.FormatInt(interval.Milliseconds(), 10)
+		}
+		return db2Duration(interval)
+	})
+
+	var expandErr error
+	sql = replaceMacros(sql, macroPattern, func(m []string) string {
+		macro, name, args := m[0], m[1], splitMacroArgs(m[2])
+
+		switch name {
+		case "timeFilter":
+			if len(args) != 1 {
+				expandErr = fmt.Errorf("$__timeFilter expects a single column, got %q", m[2])
+				return macro
+			}
+			return timeFilter(args[0], query.TimeRange)
+		case "timeFrom":
+			return db2Timestamp(query.TimeRange.From)
+		case "timeTo":
+			return db2Timestamp(query.TimeRange.To)
+		case "unixEpochFilter":
+			if len(args) != 1 {
+				expandErr = fmt.Errorf("$__unixEpochFilter expects a single column, got %q", m[2])
+				return macro
+			}
+			return fmt.Sprintf("%s BETWEEN %d AND %d", args[0], query.TimeRange.From.Unix(), query.TimeRange.To.Unix())
+		case "unixEpochGroup":
+			if len(args) < 1 || len(args) > 2 {
+				expandErr = fmt.Errorf("$__unixEpochGroup expects a column and an optional interval, got %q", m[2])
+				return macro
+			}
+			//Without an interval, the buckets follow the panel's interval like $__interval.
+			bucket := interval
+			if len(args) == 2 {
+				var err error
+				if bucket, err = time.ParseDuration(args[1]); err != nil {
+					expandErr = fmt.Errorf("$__unixEpochGroup: invalid interval %q", args[1])
+					return macro
+				}
+			}
+			seconds := int64(bucket / time.Second)
+			if seconds < 1 {
+				seconds = 1
+			}
+			return fmt.Sprintf("(%s / %d) * %d", args[0], seconds, seconds)
+		default:
+			expandErr = fmt.Errorf("unknown macro $__%s", name)
+			return macro
+		}
+	})
+
+	if expandErr != nil {
+		return "", expandErr
+	}
+
+	return sql, nil
+}
+
+// replaceMacros replaces the matches of pattern in sql by the result of replace, which gets
+// the match and its submatches. Matches in string literals, delimited identifiers and
+// comments are left as they are.
+func replaceMacros(sql string, pattern *regexp.Regexp, replace func(m []string) string) string {
+	masked := maskSQL(sql)
+
+	var b strings.Builder
+	last := 0
+	for _, loc := range pattern.FindAllStringSubmatchIndex(sql, -1) {
+		//Masking blanks the $ of a macro that isn't part of the statement itself.
+		if masked[loc[0]] != '$' {
+			continue
+		}
+
+		m := make([]string, len(loc)/2)
+		for i := range m {
+			if loc[2*i] >= 0 {
+				m[i] = sql[loc[2*i]:loc[2*i+1]]
+			}
+		}
+		b.WriteString(sql[last:loc[0]])
+		b.WriteString(replace(m))
+		last = loc[1]
+	}
+	b.WriteString(sql[last:])
+
+	return b.String()
+}
+
+// Parameter values that are bound as the bounds of the query's time range.
+const (
+	paramTimeFrom = "$__timeFrom"
+	paramTimeTo   = "$__timeTo"
+)
+
+// queryArgs returns the values bound to the ? parameter markers of the query, in order.
+// The $__timeFrom and $__timeTo values are bound as timestamps, and whole numbers as
+// integers. Unlike the macros, bound values are never spliced into the SQL text.
+func queryArgs(params []interface{}, timeRange backend.TimeRange) ([]interface{}, error) {
+	args := make([]interface{}, len(params))
+	for i, param := range params {
+		switch v := param.(type) {
+		case nil, bool:
+			args[i] = v
+		case string:
+			switch v {
+			case paramTimeFrom:
+				args[i] = timeRange.From.UTC()
+			case paramTimeTo:
+				args[i] = timeRange.To.UTC()
+			default:
+				args[i] = v
+			}
+		case float64:
+			//JSON numbers are floats, integer columns want whole numbers as integers.
+			if v == float64(int64(v)) {
+				args[i] = int64(v)
+			} else {
+				args[i] = v
+			}
+		default:
+			return nil, fmt.Errorf("parameter %d: unsupported value of type %T", i+1, param)
+		}
+	}
+	return args, nil
+}
+
+func splitMacroArgs(args string) []string {
+	var split []string
+	for _, arg := range strings.Split(args, ",") {
+		if arg = strings.TrimSpace(arg); arg != "" {
+			split = append(split, arg)
+		}
+	}
+	return split
+}
+
+// macroInterval returns the bucket size for the interval macros. Grafana sets the interval
+// of the query from the panel width, otherwise it is derived from the max data points.
+func macroInterval(query backend.DataQuery) time.Duration {
+	interval := query.Interval
+	if interval <= 0 && query.MaxDataPoints > 0 {
+		interval = query.TimeRange.To.Sub(query.TimeRange.From) / time.Duration(query.MaxDataPoints)
+	}
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	return interval
+}
+
+// db2Duration formats an interval as a Db2 labeled duration, like 30 SECONDS, which can be
+// added to a timestamp.
+func db2Duration(interval time.Duration) string {
+	if interval%time.Second == 0 {
+		return fmt.Sprintf("%d SECONDS", interval/time.Second)
+	}
+	return fmt.Sprintf("%d MICROSECONDS", interval/time.Microsecond)
+}
+
+func db2Timestamp(t time.Time) string {
+	return "'" + t.UTC().Format(db2TimestampLayout) + "'"
+}
+
+func timeFilter(column string, timeRange backend.TimeRange) string {
+	return fmt.Sprintf("%s BETWEEN %s AND %s", column, db2Timestamp(timeRange.From), db2Timestamp(timeRange.To))
+}
+
+// commonTableExpression is the position of a named CTE's fullselect in a statement,
+// between its parentheses.
+type commonTableExpression struct {
+	name      string
+	bodyStart int
+	bodyEnd   int
+}
+
+// findCTEs returns the common table expressions of a statement starting with WITH.
+func findCTEs(sql string) []commonTableExpression {
+	masked := maskSQL(sql)
+	pos := 0
+
+	skipSpace := func() {
+		for pos < len(masked) && strings.ContainsRune(" \t\r\n", rune(masked[pos])) {
+			pos++
+		}
+	}
+	word := func() string {
+		start := pos
+		for pos < len(masked) && (masked[pos] == '_' || masked[pos] >= 'A' && masked[pos] <= 'Z' || masked[pos] >= '0' && masked[pos] <= '9') {
+			pos++
+		}
+		return masked[start:pos]
+	}
+	// closing returns the position of the parenthesis closing the one at pos.
+	closing := func() int {
+		depth := 0
+		for i := pos; i < len(masked); i++ {
+			switch masked[i] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+				if depth == 0 {
+					return i
+				}
+			}
+		}
+		return -1
+	}
+
+	skipSpace()
+	if word() != "WITH" {
+		return nil
+	}
+
+	var ctes []commonTableExpression
+	for {
+		skipSpace()
+		name := word()
+		if name == "" {
+			return ctes
+		}
+
+		//Skip the optional column list.
+		skipSpace()
+		if pos < len(masked) && masked[pos] == '(' {
+			if pos = closing(); pos < 0 {
+				return ctes
+			}
+			pos++
+			skipSpace()
+		}
+
+		if word() != "AS" {
+			return ctes
+		}
+		skipSpace()
+		if pos >= len(masked) || masked[pos] != '(' {
+			return ctes
+		}
+
+		end := closing()
+		if end < 0 {
+			return ctes
+		}
+		ctes = append(ctes, commonTableExpression{name: name, bodyStart: pos + 1, bodyEnd: end})
+		pos = end + 1
+
+		skipSpace()
+		if pos >= len(masked) || masked[pos] != ',' {
+			return ctes
+		}
+		pos++
+	}
+}
+
+var (
+	wherePattern = regexp.MustCompile(`\bWHERE\b`)
+	// Clauses that have to follow the WHERE clause of a subselect.
+	clauseAfterWherePattern = regexp.MustCompile(`\b(GROUP\s+BY|HAVING|ORDER\s+BY|FETCH\s+(FIRST|NEXT)|UNION|EXCEPT|INTERSECT)\b`)
+	setOperatorPattern      = regexp.MustCompile(`\b(UNION|EXCEPT|INTERSECT)\b`)
+)
+
+// pushDownTimeFilters moves $__timeFilter(cte.column) macros that are qualified with the name
+// of a common table expression into that CTE, so Db2 filters the rows where they're read.
+// The macro itself is replaced by an always true predicate. A CTE that combines subselects
+// with UNION, EXCEPT or INTERSECT keeps the macro outside, a single WHERE can't filter
+// all of its subselects.
+func pushDownTimeFilters(sql string, timeRange backend.TimeRange) string {
+	for {
+		ctes := findCTEs(sql)
+		masked := maskSQL(sql)
+		var target *commonTableExpression
+		var loc []int
+
+		for _, m := range timeFilterPattern.FindAllStringSubmatchIndex(sql, -1) {
+			if masked[m[0]] != '$' {
+				continue
+			}
+			qualifier := strings.ToUpper(sql[m[2]:m[3]])
+			for i := range ctes {
+				//Only macros outside of the CTE's own body are pushed down.
+				if ctes[i].name == qualifier && (m[0] < ctes[i].bodyStart || m[0] > ctes[i].bodyEnd) &&
+					!setOperatorPattern.MatchString(topLevelSQL(maskSQL(sql[ctes[i].bodyStart:ctes[i].bodyEnd]))) {
+					target, loc = &ctes[i], m
+					break
+				}
+			}
+			if target != nil {
+				break
+			}
+		}
+
+		if target == nil {
+			return sql
+		}
+
+		column := sql[loc[4]:loc[5]]
+		predicate := timeFilter(column, timeRange)
+
+		body := sql[target.bodyStart:target.bodyEnd]
+		top := topLevelSQL(maskSQL(body))
+
+		insertAt := len(body)
+		whereLoc := wherePattern.FindStringIndex(top)
+		searchFrom := 0
+		if whereLoc != nil {
+			searchFrom = whereLoc[1]
+		}
+		if clause := clauseAfterWherePattern.FindStringIndex(top[searchFrom:]); clause != nil {
+			insertAt = searchFrom + clause[0]
+		}
+
+		//The existing condition is parenthesized so an OR in it can't bypass the filter. The
+		//predicate goes on a line of its own, so a trailing line comment can't comment it out.
+		var newBody string
+		if whereLoc != nil {
+			condition := strings.TrimSpace(body[whereLoc[1]:insertAt])
+			newBody = body[:whereLoc[1]] + " (" + condition + "\n) AND " + predicate + "\n" + body[insertAt:]
+		} else {
+			newBody = strings.TrimSpace(body[:insertAt]) + "\nWHERE " + predicate + "\n" + body[insertAt:]
+		}
+
+		//Replace the macro first, it comes after the CTE body.
+		if loc[0] > target.bodyEnd {
+			sql = sql[:loc[0]] + "1=1" + sql[loc[1]:]
+			sql = sql[:target.bodyStart] + newBody + sql[target.bodyEnd:]
+		} else {
+			sql = sql[:target.bodyStart] + newBody + sql[target.bodyEnd:]
+			sql = sql[:loc[0]] + "1=1" + sql[loc[1]:]
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// testTimeRange is the time range of the macro tests, From is 1614600000 in Unix seconds.
+var testTimeRange = backend.TimeRange{
+	From: time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC),
+	To:   time.Date(2021, 3, 1, 13, 0, 0, 0, time.UTC),
+}
+
+func TestExpandMacros(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		from     time.Time // Replaces the start of the time range when set.
+		interval time.Duration
+		want     string
+		wantErr  bool
+	}{
+		{
+			name: "no macros",
+			sql:  "SELECT * FROM SALES",
+			want: "SELECT * FROM SALES",
+		},
+		{
+			name: "time filter",
+			sql:  "SELECT * FROM SALES WHERE $__timeFilter(SOLD_AT)",
+			want: "SELECT * FROM SALES WHERE SOLD_AT BETWEEN '2021-03-01 12:00:00' AND '2021-03-01 13:00:00'",
+		},
+		{
+			name: "time from in another zone is written in UTC",
+			sql:  "$__timeFrom()",
+			from: time.Date(2021, 3, 1, 13, 0, 0, 0, time.FixedZone("CET", 3600)),
+			want: "'2021-03-01 12:00:00'",
+		},
+		{
+			name: "time from and to",
+			sql:  "DATE($__timeFrom()), $__timeTo()",
+			want: "DATE('2021-03-01 12:00:00'), '2021-03-01 13:00:00'",
+		},
+		{
+			name: "unix epoch filter",
+			sql:  "WHERE $__unixEpochFilter(EPOCH)",
+			want: "WHERE EPOCH BETWEEN 1614600000 AND 1614603600",
+		},
+		{
+			name:     "unix epoch group on the interval",
+			sql:      "$__unixEpochGroup(EPOCH)",
+			interval: time.Minute,
+			want:     "(EPOCH / 60) * 60",
+		},
+		{
+			name: "unix epoch group on its own interval",
+			sql:  "$__unixEpochGroup(EPOCH, 5m)",
+			want: "(EPOCH / 300) * 300",
+		},
+		{
+			name:     "interval",
+			sql:      "TS + $__interval, $__interval_ms",
+			interval: 30 * time.Second,
+			want:     "TS + 30 SECONDS, 30000",
+		},
+		{
+			name:     "sub-second interval",
+			sql:      "$__interval()",
+			interval: 1500 * time.Millisecond,
+			want:     "1500000 MICROSECONDS",
+		},
+		{
+			name: "time filter pushed into a CTE",
+			sql:  "WITH RECENT AS (SELECT * FROM SALES) SELECT * FROM RECENT WHERE $__timeFilter(recent.SOLD_AT)",
+			want: "WITH RECENT AS (SELECT * FROM SALES\nWHERE SOLD_AT BETWEEN '2021-03-01 12:00:00' AND '2021-03-01 13:00:00'\n) SELECT * FROM RECENT WHERE 1=1",
+		},
+		{
+			name: "time filter pushed into a CTE with a condition",
+			sql:  "WITH RECENT AS (SELECT * FROM SALES WHERE A = 1 OR B = 2 ORDER BY SOLD_AT) SELECT * FROM RECENT WHERE $__timeFilter(RECENT.SOLD_AT)",
+			want: "WITH RECENT AS (SELECT * FROM SALES WHERE (A = 1 OR B = 2\n) AND SOLD_AT BETWEEN '2021-03-01 12:00:00' AND '2021-03-01 13:00:00'\nORDER BY SOLD_AT) SELECT * FROM RECENT WHERE 1=1",
+		},
+		{
+			name: "time filter kept outside a CTE with a union",
+			sql:  "WITH ALL_SALES AS (SELECT SOLD_AT FROM SALES UNION ALL SELECT SOLD_AT FROM RETURNS WHERE QTY > 0) SELECT * FROM ALL_SALES WHERE $__timeFilter(ALL_SALES.SOLD_AT)",
+			want: "WITH ALL_SALES AS (SELECT SOLD_AT FROM SALES UNION ALL SELECT SOLD_AT FROM RETURNS WHERE QTY > 0) SELECT * FROM ALL_SALES WHERE ALL_SALES.SOLD_AT BETWEEN '2021-03-01 12:00:00' AND '2021-03-01 13:00:00'",
+		},
+		{
+			name: "time filter pushed into a CTE with a union in a subselect",
+			sql:  "WITH RECENT AS (SELECT * FROM (SELECT * FROM SALES UNION ALL SELECT * FROM RETURNS) AS S) SELECT * FROM RECENT WHERE $__timeFilter(RECENT.SOLD_AT)",
+			want: "WITH RECENT AS (SELECT * FROM (SELECT * FROM SALES UNION ALL SELECT * FROM RETURNS) AS S\nWHERE SOLD_AT BETWEEN '2021-03-01 12:00:00' AND '2021-03-01 13:00:00'\n) SELECT * FROM RECENT WHERE 1=1",
+		},
+		{
+			name: "macros in literals and comments",
+			sql:  "SELECT '$__foo()', \"$__interval\" FROM SALES -- $__timeFilter()\nWHERE $__timeFilter(SOLD_AT) /* $__timeTo() */",
+			want: "SELECT '$__foo()', \"$__interval\" FROM SALES -- $__timeFilter()\nWHERE SOLD_AT BETWEEN '2021-03-01 12:00:00' AND '2021-03-01 13:00:00' /* $__timeTo() */",
+		},
+		{
+			name: "time filter in a literal isn't pushed into a CTE",
+			sql:  "WITH RECENT AS (SELECT * FROM SALES) SELECT '$__timeFilter(RECENT.SOLD_AT)' FROM RECENT",
+			want: "WITH RECENT AS (SELECT * FROM SALES) SELECT '$__timeFilter(RECENT.SOLD_AT)' FROM RECENT",
+		},
+		{
+			name:    "time filter without a column",
+			sql:     "$__timeFilter()",
+			wantErr: true,
+		},
+		{
+			name:    "invalid epoch group interval",
+			sql:     "$__unixEpochGroup(EPOCH, soon)",
+			wantErr: true,
+		},
+		{
+			name:    "unknown macro",
+			sql:     "$__timeGroup(TS, 5m)",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timeRange := testTimeRange
+			if !tt.from.IsZero() {
+				timeRange.From = tt.from
+			}
+			got, err := expandMacros(tt.sql, backend.DataQuery{TimeRange: timeRange, Interval: tt.interval})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandMacros() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("expandMacros() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMacroInterval(t *testing.T) {
+	tests := []struct {
+		name  string
+		query backend.DataQuery
+		want  time.Duration
+	}{
+		{name: "query interval", query: backend.DataQuery{Interval: time.Minute}, want: time.Minute},
+		{name: "from max data points", query: backend.DataQuery{TimeRange: testTimeRange, MaxDataPoints: 120}, want: 30 * time.Second},
+		{name: "at least a millisecond", query: backend.DataQuery{}, want: time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := macroInterval(tt.query); got != tt.want {
+				t.Errorf("macroInterval() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  []interface{}
+		want    []interface{}
+		wantErr bool
+	}{
+		{name: "none", params: nil, want: []interface{}{}},
+		{name: "time range", params: []interface{}{"$__timeFrom", "$__timeTo"}, want: []interface{}{testTimeRange.From, testTimeRange.To}},
+		{name: "whole numbers become integers", params: []interface{}{float64(42), 1.5}, want: []interface{}{int64(42), 1.5}},
+		{name: "strings, booleans and nulls", params: []interface{}{"EU", true, nil}, want: []interface{}{"EU", true, nil}},
+		{name: "unsupported", params: []interface{}{[]interface{}{"EU"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := queryArgs(tt.params, testTimeRange)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("queryArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("queryArgs() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("queryArgs()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
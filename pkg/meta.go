@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// setCustomMeta stores value under key in the custom metadata of the frame.
+func setCustomMeta(frame *data.Frame, key string, value interface{}) {
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+
+	custom, ok := frame.Meta.Custom.(map[string]interface{})
+	if !ok {
+		custom = map[string]interface{}{}
+		frame.Meta.Custom = custom
+	}
+
+	custom[key] = value
+}
+
+// setExecutedQuery records the SQL sent to Db2, with its macros expanded and the row limit
+// added, for Grafana's query inspector to show.
+func setExecutedQuery(frame *data.Frame, queryText string) {
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+	frame.Meta.ExecutedQueryString = queryText
+}
+
+// copyMeta returns a copy of meta for a frame derived from another, whose notices and
+// custom metadata can be changed without changing those of the original.
+func copyMeta(meta *data.FrameMeta) *data.FrameMeta {
+	if meta == nil {
+		return nil
+	}
+
+	copied := *meta
+	copied.Notices = append([]data.Notice(nil), meta.Notices...)
+	if custom, ok := meta.Custom.(map[string]interface{}); ok {
+		copiedCustom := make(map[string]interface{}, len(custom))
+		for key, value := range custom {
+			copiedCustom[key] = value
+		}
+		copied.Custom = copiedCustom
+	}
+
+	return &copied
+}
+
+// addNotice adds a notice to the frame's metadata, for the panel to show.
+func addNotice(frame *data.Frame, severity data.NoticeSeverity, text string) {
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+	frame.Meta.Notices = append(frame.Meta.Notices, data.Notice{Severity: severity, Text: text})
+}
+
+// fieldStats summarizes the values of a numeric field. The values are nil when the field
+// has no non-null values.
+type fieldStats struct {
+	Min  *float64 `json:"min"`
+	Max  *float64 `json:"max"`
+	Last *float64 `json:"last"`
+}
+
+// computeStats returns the stats of every numeric field in the frame, keyed by field name.
+func computeStats(frame *data.Frame) map[string]fieldStats {
+	stats := make(map[string]fieldStats)
+
+	for _, field := range frame.Fields {
+		if !field.Type().Numeric() {
+			continue
+		}
+
+		var s fieldStats
+		for i := 0; i < field.Len(); i++ {
+			v, err := field.FloatAt(i)
+			if err != nil || math.IsNaN(v) {
+				continue
+			}
+
+			value := v
+			if s.Min == nil || v < *s.Min {
+				s.Min = &value
+			}
+			if s.Max == nil || v > *s.Max {
+				s.Max = &value
+			}
+			s.Last = &value
+		}
+
+		stats[field.Name] = s
+	}
+
+	return stats
+}
+
+// schemaFingerprint returns a hash of the names and types of the frame's fields, in order.
+// It only changes when the result schema of the query changes.
+func schemaFingerprint(frame *data.Frame) string {
+	h := sha256.New()
+	for _, field := range frame.Fields {
+		h.Write([]byte(field.Name + "\x00" + field.Type().ItemTypeString() + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// executionMeta describes how a query ran. It is added under "execution" to the metadata
+// of the first frame of the query, which holds the query's notices, so a query stats panel
+// can rely on its shape. A union keeps the one of its first query.
+type executionMeta struct {
+	DurationMs int64    `json:"durationMs"`
+	Rows       int      `json:"rows"`
+	Truncated  bool     `json:"truncated"`
+	Warnings   []string `json:"warnings"`
+}
+
+// setExecutionMeta adds the execution metadata of a query that started at start and
+// fetched rows rows. The warnings are the notices on the frame.
+func setExecutionMeta(frame *data.Frame, start time.Time, rows int, truncated bool) {
+	meta := executionMeta{
+		DurationMs: time.Since(start).Milliseconds(),
+		Rows:       rows,
+		Truncated:  truncated,
+		Warnings:   []string{},
+	}
+
+	if frame.Meta != nil {
+		for _, notice := range frame.Meta.Notices {
+			meta.Warnings = append(meta.Warnings, notice.Text)
+		}
+	}
+
+	setCustomMeta(frame, "execution", meta)
+}
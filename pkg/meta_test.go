@@ -0,0 +1,122 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestSetExecutionMeta(t *testing.T) {
+	tests := []struct {
+		name      string
+		notices   []string
+		rows      int
+		truncated bool
+		want      executionMeta
+	}{
+		{name: "complete", rows: 3, want: executionMeta{Rows: 3, Warnings: []string{}}},
+		{
+			name:      "truncated with notices",
+			notices:   []string{"The result was limited to 2 rows"},
+			rows:      2,
+			truncated: true,
+			want:      executionMeta{Rows: 2, Truncated: true, Warnings: []string{"The result was limited to 2 rows"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := data.NewFrame("")
+			for _, text := range tt.notices {
+				addNotice(frame, data.NoticeSeverityWarning, text)
+			}
+
+			start := time.Now().Add(-1500 * time.Millisecond)
+			setExecutionMeta(frame, start, tt.rows, tt.truncated)
+
+			got, ok := frame.Meta.Custom.(map[string]interface{})["execution"].(executionMeta)
+			if !ok {
+				t.Fatalf("execution meta = %v", frame.Meta.Custom)
+			}
+			if got.DurationMs < 1500 || got.DurationMs > 2500 {
+				t.Errorf("DurationMs = %d, want about 1500", got.DurationMs)
+			}
+			got.DurationMs = 0
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("execution meta = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeStats(t *testing.T) {
+	tests := []struct {
+		name  string
+		field *data.Field
+		want  map[string]fieldStats
+	}{
+		{
+			name:  "float",
+			field: data.NewField("CPU", nil, []float64{3, 1, 2}),
+			want:  map[string]fieldStats{"CPU": {Min: float64Ptr(1), Max: float64Ptr(3), Last: float64Ptr(2)}},
+		},
+		{
+			name:  "nulls and NaN are skipped",
+			field: data.NewField("CPU", nil, []*float64{float64Ptr(2), nil, float64Ptr(math.NaN()), float64Ptr(5), nil}),
+			want:  map[string]fieldStats{"CPU": {Min: float64Ptr(2), Max: float64Ptr(5), Last: float64Ptr(5)}},
+		},
+		{
+			name:  "integer",
+			field: data.NewField("ROWS_READ", nil, []int64{10, -4}),
+			want:  map[string]fieldStats{"ROWS_READ": {Min: float64Ptr(-4), Max: float64Ptr(10), Last: float64Ptr(-4)}},
+		},
+		{
+			name:  "only nulls",
+			field: data.NewField("CPU", nil, []*float64{nil, nil}),
+			want:  map[string]fieldStats{"CPU": {}},
+		},
+		{
+			name:  "not numeric",
+			field: data.NewField("HOST", nil, []string{"db1"}),
+			want:  map[string]fieldStats{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := computeStats(data.NewFrame("", tt.field)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("computeStats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchemaFingerprint(t *testing.T) {
+	frame := func(fields ...*data.Field) *data.Frame { return data.NewFrame("", fields...) }
+	base := frame(data.NewField("TS", nil, []time.Time{}), data.NewField("CPU", nil, []float64{}))
+	want := schemaFingerprint(base)
+
+	tests := []struct {
+		name      string
+		frame     *data.Frame
+		wantEqual bool
+	}{
+		{name: "same frame again", frame: base, wantEqual: true},
+		{name: "same schema, other values", frame: frame(data.NewField("TS", nil, []time.Time{time.Now()}), data.NewField("CPU", nil, []float64{1})), wantEqual: true},
+		{name: "renamed field", frame: frame(data.NewField("TS", nil, []time.Time{}), data.NewField("MEM", nil, []float64{}))},
+		{name: "retyped field", frame: frame(data.NewField("TS", nil, []time.Time{}), data.NewField("CPU", nil, []*float64{}))},
+		{name: "added field", frame: frame(data.NewField("TS", nil, []time.Time{}), data.NewField("CPU", nil, []float64{}), data.NewField("MEM", nil, []float64{}))},
+		{name: "reordered fields", frame: frame(data.NewField("CPU", nil, []float64{}), data.NewField("TS", nil, []time.Time{}))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := schemaFingerprint(tt.frame); (got == want) != tt.wantEqual {
+				t.Errorf("schemaFingerprint() = %s, base %s, want equal %v", got, want, tt.wantEqual)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics of the queries and health checks, per datasource and status. They're registered
+// with the default registry, which the SDK exposes to Grafana's plugin metrics endpoint.
+var (
+	queriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "db2_datasource",
+		Name:      "queries_total",
+		Help:      "Number of queries run, by datasource and status.",
+	}, []string{"datasource", "status"})
+
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "db2_datasource",
+		Name:      "query_duration_seconds",
+		Help:      "Duration of queries, by datasource and status.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"datasource", "status"})
+
+	healthChecksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "db2_datasource",
+		Name:      "health_checks_total",
+		Help:      "Number of health checks run, by datasource and status.",
+	}, []string{"datasource", "status"})
+)
+
+// metricStatus returns the status label of an outcome.
+func metricStatus(ok bool) string {
+	if ok {
+		return "success"
+	}
+	return "error"
+}
+
+// observeQuery counts a query of datasource and records its duration.
+func observeQuery(datasource string, start time.Time, ok bool) {
+	status := metricStatus(ok)
+	queriesTotal.WithLabelValues(datasource, status).Inc()
+	queryDuration.WithLabelValues(datasource, status).Observe(time.Since(start).Seconds())
+}
+
+// observeHealthCheck counts a health check of datasource.
+func observeHealthCheck(datasource string, ok bool) {
+	healthChecksTotal.WithLabelValues(datasource, metricStatus(ok)).Inc()
+}
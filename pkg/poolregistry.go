@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	db2 "github.com/ibmdb/go_ibm_db"
+)
+
+// defaultConnMaxLifetime applies when the datasource doesn't set a connection lifetime.
+const defaultConnMaxLifetime = 60 * time.Second
+
+// poolConfig holds the pool settings of a datasource.
+type poolConfig struct {
+	size            int
+	connMaxLifetime time.Duration
+	maxIdleConns    int // 0 keeps the database/sql default.
+}
+
+func (c poolConfig) String() string {
+	return fmt.Sprintf("size=%d lifetime=%s idle=%d", c.size, c.connMaxLifetime, c.maxIdleConns)
+}
+
+// driverMu serializes the calls into go_ibm_db's pooling. Pconnect and Open update package
+// globals of the driver, and Open and Release the maps of a pool, all without locking. Pools
+// are created and released concurrently, /reload runs outside the instance manager's lock.
+var driverMu sync.Mutex
+
+// newPool creates a pool of the configured size.
+func (c poolConfig) newPool() *db2.Pool {
+	driverMu.Lock()
+	defer driverMu.Unlock()
+
+	return db2.Pconnect(fmt.Sprintf("PoolSize=%d", c.size))
+}
+
+// open opens a handle for constr from the pool. The handle's sql.DB keeps at most as many
+// connections open as the pool size, for at most the connection lifetime. The lifetime is
+// set on the handle too, Open only applies its SetConnMaxLifetime option while the driver's
+// process-wide handle count is below the pool size, and that count never goes down.
+func (c poolConfig) open(pool *db2.Pool, constr string) *db2.DBP {
+	driverMu.Lock()
+	defer driverMu.Unlock()
+
+	handle := pool.Open(constr, fmt.Sprintf("SetConnMaxLifetime=%d", int(c.connMaxLifetime/time.Second)))
+	if handle == nil {
+		return nil
+	}
+
+	handle.SetMaxOpenConns(c.size)
+	handle.SetConnMaxLifetime(c.connMaxLifetime)
+	if c.maxIdleConns > 0 {
+		handle.SetMaxIdleConns(c.maxIdleConns)
+	}
+
+	return handle
+}
+
+// closePool closes the handles opened from pool and releases it. Release closes the handles
+// the pool keeps track of, a handle opened past the driver's pool size isn't, so the handles
+// are closed too. DBP.Close would hand them back to the driver's most recently created pool
+// instead, where another instance can be holding the same connection string.
+func closePool(pool *db2.Pool, handles ...*db2.DBP) {
+	driverMu.Lock()
+	defer driverMu.Unlock()
+
+	for _, handle := range handles {
+		if handle != nil {
+			handle.DB.Close()
+		}
+	}
+	pool.Release()
+}
+
+// sharedPools is the process-wide registry of the pools shared by datasources that connect
+// to the same Db2 with the same credentials.
+var sharedPools = &poolRegistry{pools: map[string]*sharedPool{}}
+
+type sharedPool struct {
+	config  poolConfig
+	pool    *db2.Pool
+	handles map[string]*db2.DBP // By connection string, opened once for all users.
+	refs    int
+}
+
+// poolRegistry hands out one pool per connection fingerprint, and releases it when the
+// last datasource using it is disposed.
+type poolRegistry struct {
+	mu    sync.Mutex
+	pools map[string]*sharedPool
+}
+
+// poolFingerprint returns the registry key of a set of connection strings. The strings
+// hold the password, so only their hash is kept.
+func poolFingerprint(constrs ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(constrs, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// acquire returns the pool for key, creating it with config for the first user.
+func (r *poolRegistry) acquire(key string, config poolConfig) *db2.Pool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	shared, ok := r.pools[key]
+	if !ok {
+		shared = &sharedPool{
+			config:  config,
+			pool:    config.newPool(),
+			handles: map[string]*db2.DBP{},
+		}
+		r.pools[key] = shared
+	}
+	shared.refs++
+
+	return shared.pool
+}
+
+// open returns the handle for constr from the shared pool for key, opening it for the first user.
+func (r *poolRegistry) open(key, constr string) *db2.DBP {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	shared, ok := r.pools[key]
+	if !ok {
+		return nil
+	}
+
+	handle, ok := shared.handles[constr]
+	if !ok {
+		handle = shared.config.open(shared.pool, constr)
+		if handle == nil {
+			return nil
+		}
+		shared.handles[constr] = handle
+	}
+
+	return handle
+}
+
+// release drops a reference to the pool for key, and closes its handles and connections
+// once nobody uses it anymore.
+func (r *poolRegistry) release(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	shared, ok := r.pools[key]
+	if !ok {
+		return
+	}
+
+	shared.refs--
+	if shared.refs <= 0 {
+		delete(r.pools, key)
+		handles := make([]*db2.DBP, 0, len(shared.handles))
+		for _, handle := range shared.handles {
+			handles = append(handles, handle)
+		}
+		closePool(shared.pool, handles...)
+	}
+}
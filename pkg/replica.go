@@ -0,0 +1,43 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// replicaBackoff is how long queries skip the read-only replica after it failed with a
+// connection error.
+const replicaBackoff = 30 * time.Second
+
+// replicaStatus tracks whether the read-only replica is worth trying. After a connection
+// error, queries go straight to the primary until the backoff has passed, rather than each
+// waiting for the unreachable replica to fail first.
+type replicaStatus struct {
+	mu        sync.Mutex
+	downUntil time.Time
+	backoff   time.Duration
+	now       func() time.Time
+}
+
+func newReplicaStatus(backoff time.Duration) *replicaStatus {
+	return &replicaStatus{
+		backoff: backoff,
+		now:     time.Now,
+	}
+}
+
+// available reports whether queries should try the replica.
+func (r *replicaStatus) available() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return !r.now().Before(r.downUntil)
+}
+
+// markDown sends queries to the primary for the backoff period.
+func (r *replicaStatus) markDown() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.downUntil = r.now().Add(r.backoff)
+}
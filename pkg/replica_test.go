@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplicaStatus(t *testing.T) {
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		markDown bool
+		elapsed  time.Duration
+		want     bool
+	}{
+		{name: "never failed", want: true},
+		{name: "just failed", markDown: true, want: false},
+		{name: "within the backoff", markDown: true, elapsed: replicaBackoff - time.Second, want: false},
+		{name: "backoff passed", markDown: true, elapsed: replicaBackoff, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := start
+			r := newReplicaStatus(replicaBackoff)
+			r.now = func() time.Time { return now }
+
+			if tt.markDown {
+				r.markDown()
+			}
+			now = now.Add(tt.elapsed)
+
+			if got := r.available(); got != tt.want {
+				t.Errorf("available() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
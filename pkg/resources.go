@@ -0,0 +1,270 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+// columnInfo describes a table column for the query editor.
+type columnInfo struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Nullable  bool   `json:"nullable"`
+	Generated bool   `json:"generated"`
+	Remarks   string `json:"remarks,omitempty"`
+}
+
+// tableInfo describes a table or view for the query editor's table picker.
+type tableInfo struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // SYSCAT.TABLES type, T for tables and V for views.
+}
+
+// defaultCatalogCacheTTL applies when the datasource doesn't set a catalog cache TTL.
+const defaultCatalogCacheTTL = 60 * time.Second
+
+// newResourceMux returns the routes of the datasource's resource calls.
+func (td *Db2Datasource) newResourceMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/columns", td.handleColumns)
+	mux.HandleFunc("/reload", td.handleReload)
+	mux.HandleFunc("/schema", td.handleSchema)
+	mux.HandleFunc("/schemas", td.handleSchemas)
+	mux.HandleFunc("/tables", td.handleTables)
+	mux.HandleFunc("/test-query", td.handleTestQuery)
+	return mux
+}
+
+// instanceFromRequest returns the instance settings of the datasource a resource call was made for.
+func (td *Db2Datasource) instanceFromRequest(r *http.Request) (*instanceSettings, error) {
+	instance, err := td.im.Get(httpadapter.PluginConfigFromContext(r.Context()))
+	if err != nil {
+		return nil, err
+	}
+
+	instSetting, ok := instance.(*instanceSettings)
+	if !ok {
+		return nil, fmt.Errorf("failed getting instance settings")
+	}
+
+	return instSetting, nil
+}
+
+// handleSchemas lists the schemas of the database.
+func (td *Db2Datasource) handleSchemas(w http.ResponseWriter, r *http.Request) {
+	instSetting, err := td.instanceFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cacheKey := "schemas"
+	if cached, ok := instSetting.catalogCache.get(cacheKey); ok {
+		writeJSON(w, cached)
+		return
+	}
+
+	db := instSetting.open()
+
+	rows, err := db.QueryContext(r.Context(), "SELECT SCHEMANAME FROM SYSCAT.SCHEMATA ORDER BY SCHEMANAME")
+	if err != nil {
+		log.DefaultLogger.Warn("Schemas - failed running query", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	schemas := []string{}
+	for rows.Next() {
+		var schema string
+		err = rows.Scan(&schema)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		//Schema names are padded to the width of the catalog column.
+		schemas = append(schemas, strings.TrimSpace(schema))
+	}
+	if err = rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	instSetting.catalogCache.put(cacheKey, schemas)
+	writeJSON(w, schemas)
+}
+
+// handleTables lists the tables and views of a schema.
+func (td *Db2Datasource) handleTables(w http.ResponseWriter, r *http.Request) {
+	schema := r.URL.Query().Get("schema")
+	if schema == "" {
+		http.Error(w, "schema is required", http.StatusBadRequest)
+		return
+	}
+
+	instSetting, err := td.instanceFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cacheKey := "tables\x00" + schema
+	if cached, ok := instSetting.catalogCache.get(cacheKey); ok {
+		writeJSON(w, cached)
+		return
+	}
+
+	db := instSetting.open()
+
+	rows, err := db.QueryContext(r.Context(),
+		"SELECT TABNAME, TYPE FROM SYSCAT.TABLES WHERE TABSCHEMA = ? ORDER BY TABNAME",
+		schema)
+	if err != nil {
+		log.DefaultLogger.Warn("Tables - failed running query", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	tables := []tableInfo{}
+	for rows.Next() {
+		var t tableInfo
+		err = rows.Scan(&t.Name, &t.Type)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		t.Type = strings.TrimSpace(t.Type)
+		tables = append(tables, t)
+	}
+	if err = rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	instSetting.catalogCache.put(cacheKey, tables)
+	writeJSON(w, tables)
+}
+
+// handleColumns lists the columns of a table with their types, nullability and comments,
+// flagging the generated and identity columns that can't be written to.
+func (td *Db2Datasource) handleColumns(w http.ResponseWriter, r *http.Request) {
+	schema := r.URL.Query().Get("schema")
+	table := r.URL.Query().Get("table")
+	if schema == "" || table == "" {
+		http.Error(w, "schema and table are required", http.StatusBadRequest)
+		return
+	}
+
+	instSetting, err := td.instanceFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cacheKey := "columns\x00" + schema + "\x00" + table
+	if cached, ok := instSetting.catalogCache.get(cacheKey); ok {
+		writeJSON(w, cached)
+		return
+	}
+
+	db := instSetting.open()
+
+	rows, err := db.QueryContext(r.Context(),
+		"SELECT COLNAME, TYPENAME, NULLS, GENERATED, IDENTITY, REMARKS FROM SYSCAT.COLUMNS WHERE TABSCHEMA = ? AND TABNAME = ? ORDER BY COLNO",
+		schema, table)
+	if err != nil {
+		log.DefaultLogger.Warn("Columns - failed running query", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	columns, err := columnsFromRows(rows)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	instSetting.catalogCache.put(cacheKey, columns)
+	writeJSON(w, columns)
+}
+
+// columnsFromRows reads the SYSCAT.COLUMNS rows of handleColumns.
+func columnsFromRows(rows *sql.Rows) ([]columnInfo, error) {
+	columns := []columnInfo{}
+	for rows.Next() {
+		var c columnInfo
+		var nulls, generated, identity string
+		var remarks sql.NullString
+
+		err := rows.Scan(&c.Name, &c.Type, &nulls, &generated, &identity, &remarks)
+		if err != nil {
+			return nil, err
+		}
+
+		//GENERATED is blank for ordinary columns, IDENTITY is 'Y' for identity columns.
+		c.Type = strings.TrimSpace(c.Type)
+		c.Nullable = nulls == "Y"
+		c.Generated = strings.TrimSpace(generated) != "" || identity == "Y"
+		//REMARKS holds the column's COMMENT ON text.
+		c.Remarks = remarks.String
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
+
+// handleReload rebuilds the connection pool with the current credentials, so a rotated
+// password is picked up without saving the datasource. Only admins can reload.
+func (td *Db2Datasource) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "reload requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := httpadapter.UserFromContext(r.Context())
+	if user == nil || user.Role != "Admin" {
+		http.Error(w, "only admins can reload the datasource", http.StatusForbidden)
+		return
+	}
+
+	instSetting, err := td.instanceFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pluginContext := httpadapter.PluginConfigFromContext(r.Context())
+	if pluginContext.DataSourceInstanceSettings == nil {
+		http.Error(w, "no datasource settings in request", http.StatusBadRequest)
+		return
+	}
+
+	err = instSetting.reload(*pluginContext.DataSourceInstanceSettings)
+	if err != nil {
+		log.DefaultLogger.Warn("Reload - failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.DefaultLogger.Info("Reload - pool rebuilt for " + instSetting.name)
+	writeJSON(w, map[string]string{"status": "reloaded"})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
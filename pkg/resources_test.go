@@ -0,0 +1,75 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+)
+
+func TestColumnsFromRows(t *testing.T) {
+	columns := []fakeColumn{
+		{name: "COLNAME"}, {name: "TYPENAME"}, {name: "NULLS"}, {name: "GENERATED"}, {name: "IDENTITY"}, {name: "REMARKS"},
+	}
+
+	tests := []struct {
+		name          string
+		row           []driver.Value
+		wantType      string
+		wantNullable  bool
+		wantGenerated bool
+	}{
+		{name: "ordinary", row: []driver.Value{"HOST", "VARCHAR  ", "Y", " ", "N", nil}, wantType: "VARCHAR", wantNullable: true},
+		{name: "generated always", row: []driver.Value{"TOTAL", "DECIMAL", "N", "A", "N", nil}, wantType: "DECIMAL", wantGenerated: true},
+		{name: "generated by default", row: []driver.Value{"TOTAL", "DECIMAL", "N", "D", "N", nil}, wantType: "DECIMAL", wantGenerated: true},
+		{name: "identity", row: []driver.Value{"ID", "BIGINT", "N", " ", "Y", nil}, wantType: "BIGINT", wantGenerated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := columnsFromRows(queryFake(t, &fakeResult{columns: columns, rows: [][]driver.Value{tt.row}}))
+			if err != nil {
+				t.Fatalf("columnsFromRows() error = %v", err)
+			}
+			if len(got) != 1 {
+				t.Fatalf("columnsFromRows() returned %d columns, want 1", len(got))
+			}
+			c := got[0]
+			if c.Name != tt.row[0] || c.Type != tt.wantType || c.Nullable != tt.wantNullable || c.Generated != tt.wantGenerated {
+				t.Errorf("columnsFromRows() = %+v, want type %s, nullable %v, generated %v", c, tt.wantType, tt.wantNullable, tt.wantGenerated)
+			}
+		})
+	}
+}
+
+func TestColumnRemarks(t *testing.T) {
+	columns := []fakeColumn{
+		{name: "COLNAME"}, {name: "TYPENAME"}, {name: "NULLS"}, {name: "GENERATED"}, {name: "IDENTITY"}, {name: "REMARKS"},
+	}
+
+	tests := []struct {
+		name     string
+		remarks  driver.Value
+		wantJSON string
+	}{
+		{name: "comment", remarks: "Host the sample was taken on", wantJSON: `{"name":"HOST","type":"VARCHAR","nullable":false,"generated":false,"remarks":"Host the sample was taken on"}`},
+		{name: "no comment", remarks: nil, wantJSON: `{"name":"HOST","type":"VARCHAR","nullable":false,"generated":false}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row := []driver.Value{"HOST", "VARCHAR", "N", " ", "N", tt.remarks}
+			got, err := columnsFromRows(queryFake(t, &fakeResult{columns: columns, rows: [][]driver.Value{row}}))
+			if err != nil {
+				t.Fatalf("columnsFromRows() error = %v", err)
+			}
+
+			body, err := json.Marshal(got[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.wantJSON {
+				t.Errorf("column = %s, want %s", body, tt.wantJSON)
+			}
+		})
+	}
+}
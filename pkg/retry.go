@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// defaultRetryBackoff is the wait before the first retry, when retries are enabled
+// without setting a backoff.
+const defaultRetryBackoff = 200 * time.Millisecond
+
+// retryPolicy retries operations that fail on a transient connection error, like a Db2
+// restart or a network blip. Errors in the statement itself fail right away.
+type retryPolicy struct {
+	maxRetries int
+	backoff    time.Duration // Wait before the first retry, doubled for each next one.
+}
+
+// do runs op, and runs it again while it fails with a connection error, at most maxRetries
+// times. It stops waiting for the next attempt when ctx is done.
+func (p retryPolicy) do(ctx context.Context, op func() error) error {
+	err := op()
+
+	backoff := p.backoff
+	for attempt := 1; attempt <= p.maxRetries && err != nil && isConnectionError(err); attempt++ {
+		log.DefaultLogger.Debug("Retrying after a connection error", "attempt", attempt, "backoff", backoff, "err", err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+
+		err = op()
+	}
+
+	return err
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// schemaCacheTTL is how long the result columns of a query are served from the cache.
+const schemaCacheTTL = 5 * time.Minute
+
+// resultColumn describes a column of a query's result set.
+type resultColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// describeQuery returns the result columns of a query without fetching any of its rows,
+// from the cache when the query was described recently.
+func (s *instanceSettings) describeQuery(ctx context.Context, queryText string) ([]resultColumn, error) {
+	queryText = strings.TrimRight(strings.TrimSpace(queryText), ";")
+	if cached, ok := s.schemaCache.get(queryText); ok {
+		return cached.([]resultColumn), nil
+	}
+
+	return s.describe(ctx, queryText)
+}
+
+// describe runs a query for its result columns, without fetching any of its rows, and
+// caches them. Macros are expanded for the last hour, only the shape of the result matters.
+func (s *instanceSettings) describe(ctx context.Context, queryText string) ([]resultColumn, error) {
+	queryText = strings.TrimRight(strings.TrimSpace(queryText), ";")
+	//Describing runs the query, a data-change table reference in it would change data.
+	if s.readOnly && !isReadOnly(queryText) {
+		return nil, fmt.Errorf("the datasource is read-only, only queries that don't change data can be described")
+	}
+
+	now := time.Now()
+	expanded, err := expandMacros(queryText, backend.DataQuery{
+		TimeRange: backend.TimeRange{From: now.Add(-time.Hour), To: now},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	db := s.open()
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT * FROM (%s) AS SCHEMAONLY WHERE 1 = 0", expanded))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rows.ColumnTypes(): %w", err)
+	}
+
+	columns := make([]resultColumn, len(colTypes))
+	for i, ct := range colTypes {
+		columns[i] = resultColumn{Name: ct.Name(), Type: ct.DatabaseTypeName()}
+	}
+
+	s.schemaCache.put(queryText, columns)
+	return columns, nil
+}
+
+// handleSchema returns the result columns of the query in the request body, for the
+// query editor to offer them in its options.
+func (td *Db2Datasource) handleSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "schema requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		QueryText string `json:"queryText"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.QueryText == "" {
+		http.Error(w, "queryText is required", http.StatusBadRequest)
+		return
+	}
+
+	instSetting, err := td.instanceFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	columns, err := instSetting.describeQuery(r.Context(), body.QueryText)
+	if err != nil {
+		log.DefaultLogger.Warn("Schema - failed describing query", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, columns)
+}
+
+// testQueryResult is the outcome of validating a query, with its result columns when it's valid.
+type testQueryResult struct {
+	Valid   bool           `json:"valid"`
+	Error   string         `json:"error,omitempty"`
+	Columns []resultColumn `json:"columns,omitempty"`
+}
+
+// handleTestQuery validates the query in the request body against Db2, for the query editor's
+// validate button. Unlike handleSchema it always asks Db2, and an invalid query isn't an
+// error of the call, its Db2 error is returned as the result.
+func (td *Db2Datasource) handleTestQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "test-query requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		QueryText string `json:"queryText"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.QueryText == "" {
+		http.Error(w, "queryText is required", http.StatusBadRequest)
+		return
+	}
+
+	instSetting, err := td.instanceFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), instSetting.queryTimeout)
+	defer cancel()
+
+	columns, err := instSetting.describe(ctx, body.QueryText)
+	if err != nil {
+		log.DefaultLogger.Debug("TestQuery - query is not valid", "err", err)
+		writeJSON(w, testQueryResult{Error: withDiagnostics(timeoutError(ctx, err, instSetting.queryTimeout)).Error()})
+		return
+	}
+
+	writeJSON(w, testQueryResult{Valid: true, Columns: columns})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maskSQL returns an upper case copy of the statement in which comments, string literals
+// and delimited identifiers are replaced by spaces. The copy has the same length, so
+// positions found in it apply to the original statement.
+func maskSQL(sql string) string {
+	masked := []byte(sql)
+	for i, c := range masked {
+		if 'a' <= c && c <= 'z' {
+			masked[i] = c - 'a' + 'A'
+		}
+	}
+
+	for i := 0; i < len(masked); i++ {
+		switch {
+		case strings.HasPrefix(sql[i:], "--"):
+			for i < len(masked) && masked[i] != '\n' {
+				masked[i] = ' '
+				i++
+			}
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			stop := len(masked)
+			if end >= 0 {
+				stop = i + 2 + end + 2
+			}
+			for ; i < stop; i++ {
+				masked[i] = ' '
+			}
+			i--
+		case sql[i] == '\'' || sql[i] == '"':
+			quote := sql[i]
+			masked[i] = ' '
+			for i++; i < len(masked); i++ {
+				masked[i] = ' '
+				if sql[i] == quote {
+					//A doubled quote is an escaped quote inside the literal.
+					if i+1 < len(sql) && sql[i+1] == quote {
+						i++
+						masked[i] = ' '
+						continue
+					}
+					break
+				}
+			}
+		}
+	}
+
+	return string(masked)
+}
+
+// topLevelSQL blanks everything between parentheses in a masked statement, leaving
+// only the clauses of the outer statement.
+func topLevelSQL(masked string) string {
+	top := []byte(masked)
+	depth := 0
+
+	for i, c := range top {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			top[i] = ' '
+			continue
+		}
+		if depth > 0 {
+			top[i] = ' '
+		}
+	}
+
+	return string(top)
+}
+
+var (
+	rowLimitPattern = regexp.MustCompile(`\b(FETCH\s+(FIRST|NEXT)|LIMIT\s+\d)`)
+	// Clauses that have to follow the FETCH FIRST clause of a select statement.
+	trailingClausePattern = regexp.MustCompile(`\b(WITH\s+(UR|CS|RS|RR)|FOR\s+(READ|FETCH)\s+ONLY|FOR\s+UPDATE|OPTIMIZE\s+FOR|SKIP\s+LOCKED)\b`)
+)
+
+// isSelect returns whether the statement is a query, starting with SELECT or WITH.
+func isSelect(masked string) bool {
+	fields := strings.Fields(masked)
+	return len(fields) > 0 && (strings.HasPrefix(fields[0], "SELECT") || fields[0] == "WITH")
+}
+
+var (
+	readStatementPattern = regexp.MustCompile(`^[\s(]*(SELECT|WITH|VALUES)\b`)
+	// Data-change table references let a query run an INSERT, UPDATE, DELETE or MERGE,
+	// like SELECT * FROM OLD TABLE (DELETE FROM t).
+	dataChangePattern = regexp.MustCompile(`\b(OLD|NEW|FINAL)\s+TABLE\b`)
+)
+
+// isReadOnly returns whether the statement only reads data: a single SELECT, WITH or
+// VALUES statement without data-change table references. Comments and literals are
+// ignored.
+func isReadOnly(sql string) bool {
+	masked := maskSQL(strings.TrimRight(strings.TrimSpace(sql), ";"))
+
+	//A second statement could change data after the first one reads.
+	if strings.Contains(masked, ";") {
+		return false
+	}
+
+	return readStatementPattern.MatchString(masked) && !dataChangePattern.MatchString(masked)
+}
+
+// injectRowLimit adds a FETCH FIRST n ROWS ONLY clause to a query that doesn't limit its
+// rows itself, and reports whether it did. Other statements and queries that already have
+// a limit are returned as is.
+func injectRowLimit(sql string, n int64) (string, bool) {
+	if n <= 0 {
+		return sql, false
+	}
+
+	sql = strings.TrimRight(strings.TrimSpace(sql), ";")
+	top := topLevelSQL(maskSQL(sql))
+
+	if !isSelect(top) || rowLimitPattern.MatchString(top) {
+		return sql, false
+	}
+
+	//The limit goes before isolation, FOR READ ONLY and OPTIMIZE FOR clauses. It is put
+	//on a line of its own, so a trailing line comment can't comment it out.
+	insertAt := len(sql)
+	if loc := trailingClausePattern.FindStringIndex(top); loc != nil {
+		insertAt = loc[0]
+	}
+
+	return strings.TrimSpace(strings.TrimSpace(sql[:insertAt]) + fmt.Sprintf("\nFETCH FIRST %d ROWS ONLY\n", n) + sql[insertAt:]), true
+}
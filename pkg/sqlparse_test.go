@@ -0,0 +1,102 @@
+package main
+
+import "testing"
+
+func TestMaskSQL(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{name: "upper case", sql: "select a from t", want: "SELECT A FROM T"},
+		{name: "string literal", sql: "a = 'x;y'", want: "A =      "},
+		{name: "escaped quote", sql: "'it''s' b", want: "        B"},
+		{name: "delimited identifier", sql: `"my col" c`, want: "         C"},
+		{name: "line comment", sql: "a -- b\nc", want: "A     \nC"},
+		{name: "block comment", sql: "a /* b */ c", want: "A         C"},
+		{name: "unterminated block comment", sql: "a /* b", want: "A     "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := maskSQL(tt.sql); got != tt.want {
+				t.Errorf("maskSQL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTopLevelSQL(t *testing.T) {
+	tests := []struct {
+		name   string
+		masked string
+		want   string
+	}{
+		{name: "flat", masked: "SELECT A FROM T", want: "SELECT A FROM T"},
+		{name: "subselect", masked: "SELECT A FROM (SELECT B FROM T) X", want: "SELECT A FROM                   X"},
+		{name: "nested", masked: "F(G(H)) I", want: "F       I"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := topLevelSQL(tt.masked); got != tt.want {
+				t.Errorf("topLevelSQL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsReadOnly(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want bool
+	}{
+		{name: "select", sql: "SELECT * FROM T", want: true},
+		{name: "with", sql: "with x as (select 1 from t) select * from x;", want: true},
+		{name: "values", sql: "VALUES CURRENT TIMESTAMP", want: true},
+		{name: "parenthesized select", sql: "(SELECT 1 FROM T)", want: true},
+		{name: "keyword in a literal", sql: "SELECT 'DELETE; OLD TABLE' FROM T", want: true},
+		{name: "update", sql: "UPDATE T SET A = 1", want: false},
+		{name: "call", sql: "CALL PROC()", want: false},
+		{name: "data-change table reference", sql: "SELECT * FROM OLD TABLE (DELETE FROM T)", want: false},
+		{name: "second statement", sql: "SELECT 1 FROM T; DELETE FROM T", want: false},
+		{name: "comment before an update", sql: "/* SELECT */ UPDATE T SET A = 1", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isReadOnly(tt.sql); got != tt.want {
+				t.Errorf("isReadOnly() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInjectRowLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		sql         string
+		n           int64
+		want        string
+		wantLimited bool
+	}{
+		{name: "select", sql: "SELECT * FROM T;", n: 100, want: "SELECT * FROM T\nFETCH FIRST 100 ROWS ONLY", wantLimited: true},
+		{name: "no limit", sql: "SELECT * FROM T", n: 0, want: "SELECT * FROM T"},
+		{name: "already limited", sql: "SELECT * FROM T FETCH FIRST 5 ROWS ONLY", n: 100, want: "SELECT * FROM T FETCH FIRST 5 ROWS ONLY"},
+		{name: "limit clause", sql: "SELECT * FROM T LIMIT 5", n: 100, want: "SELECT * FROM T LIMIT 5"},
+		{name: "limit in a subselect only", sql: "SELECT * FROM (SELECT * FROM T FETCH FIRST 5 ROWS ONLY) X", n: 100, want: "SELECT * FROM (SELECT * FROM T FETCH FIRST 5 ROWS ONLY) X\nFETCH FIRST 100 ROWS ONLY", wantLimited: true},
+		{name: "before the isolation clause", sql: "SELECT * FROM T WITH UR", n: 10, want: "SELECT * FROM T\nFETCH FIRST 10 ROWS ONLY\nWITH UR", wantLimited: true},
+		{name: "after a line comment", sql: "SELECT * FROM T -- all", n: 10, want: "SELECT * FROM T -- all\nFETCH FIRST 10 ROWS ONLY", wantLimited: true},
+		{name: "not a query", sql: "CALL PROC()", n: 10, want: "CALL PROC()"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, limited := injectRowLimit(tt.sql, tt.n)
+			if got != tt.want || limited != tt.wantLimited {
+				t.Errorf("injectRowLimit() = %q, %v, want %q, %v", got, limited, tt.want, tt.wantLimited)
+			}
+		})
+	}
+}
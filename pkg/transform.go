@@ -0,0 +1,457 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// roundFloatFields rounds the values of the float fields in the frame. Places per column,
+// matched case-insensitively, take precedence over the global places. Fields without
+// configured places keep their raw values.
+func roundFloatFields(frame *data.Frame, global *int, perColumn map[string]int) {
+	for _, field := range frame.Fields {
+		if field.Type() != data.FieldTypeFloat64 && field.Type() != data.FieldTypeNullableFloat64 {
+			continue
+		}
+
+		places, ok := columnDecimalPlaces(field.Name, global, perColumn)
+		if !ok {
+			continue
+		}
+		scale := math.Pow(10, float64(places))
+
+		for i := 0; i < field.Len(); i++ {
+			v, ok := field.ConcreteAt(i)
+			if !ok {
+				continue
+			}
+			field.SetConcrete(i, math.Round(v.(float64)*scale)/scale)
+		}
+	}
+}
+
+func columnDecimalPlaces(name string, global *int, perColumn map[string]int) (int, bool) {
+	for column, places := range perColumn {
+		if strings.EqualFold(column, name) {
+			return places, true
+		}
+	}
+
+	if global != nil {
+		return *global, true
+	}
+
+	return 0, false
+}
+
+// columnConfig is the display config of a column, set per query.
+type columnConfig struct {
+	Unit        string  `json:"unit"`
+	Decimals    *uint16 `json:"decimals"`
+	DisplayName string  `json:"displayName"`
+}
+
+// applyFieldConfig sets the display config of the fields named in configs, matched
+// case-insensitively. Only the settings that are set are applied.
+func applyFieldConfig(frame *data.Frame, configs map[string]columnConfig) {
+	for column, config := range configs {
+		for _, field := range frame.Fields {
+			if !strings.EqualFold(field.Name, column) {
+				continue
+			}
+
+			if field.Config == nil {
+				field.Config = &data.FieldConfig{}
+			}
+			if config.Unit != "" {
+				field.Config.Unit = config.Unit
+			}
+			if config.Decimals != nil {
+				field.Config.Decimals = config.Decimals
+			}
+			if config.DisplayName != "" {
+				field.Config.DisplayName = config.DisplayName
+			}
+		}
+	}
+}
+
+// applyUnits sets the display unit of the fields whose name ends in one of the configured
+// suffixes, matched case-insensitively, e.g. "_bytes" to "bytes". The longest matching
+// suffix wins. Fields that already have a unit are left alone.
+func applyUnits(frame *data.Frame, unitBySuffix map[string]string) {
+	if len(unitBySuffix) == 0 {
+		return
+	}
+
+	for _, field := range frame.Fields {
+		if field.Config != nil && field.Config.Unit != "" {
+			continue
+		}
+
+		name := strings.ToLower(field.Name)
+		unit, matched := "", ""
+		for suffix, u := range unitBySuffix {
+			if strings.HasSuffix(name, strings.ToLower(suffix)) && len(suffix) > len(matched) {
+				unit, matched = u, suffix
+			}
+		}
+
+		if unit != "" {
+			if field.Config == nil {
+				field.Config = &data.FieldConfig{}
+			}
+			field.Config.Unit = unit
+		}
+	}
+}
+
+// unionQueries combines the results of queries that share a union group into a single
+// frame, by appending their rows. The combined frame is returned for the first query
+// of the group, the other queries of the group return no frames. Queries in a group
+// need to return the same column names and types.
+func unionQueries(queries []backend.DataQuery, response *backend.QueryDataResponse) {
+	var groupNames []string
+	groups := make(map[string][]string)
+
+	for _, q := range queries {
+		var qm struct {
+			UnionGroup string `json:"unionGroup"`
+		}
+		if err := json.Unmarshal(q.JSON, &qm); err != nil || qm.UnionGroup == "" {
+			continue
+		}
+
+		if _, ok := groups[qm.UnionGroup]; !ok {
+			groupNames = append(groupNames, qm.UnionGroup)
+		}
+		groups[qm.UnionGroup] = append(groups[qm.UnionGroup], q.RefID)
+	}
+
+	for _, name := range groupNames {
+		refIDs := groups[name]
+		if len(refIDs) < 2 {
+			continue
+		}
+
+		union, err := unionFrames(response, refIDs)
+		if err != nil {
+			err = fmt.Errorf("union group %s: %w", name, err)
+		}
+
+		for i, refID := range refIDs {
+			res := response.Responses[refID]
+			switch {
+			case err != nil:
+				res.Frames = nil
+				if res.Error == nil {
+					res.Error = err
+				}
+			case i == 0:
+				res.Frames = data.Frames{union}
+			default:
+				res.Frames = nil
+			}
+			response.Responses[refID] = res
+		}
+	}
+}
+
+// unionFrames appends the rows of the first frame of each query's response. The combined
+// frame has the meta of the first frame, and the notices of every frame.
+func unionFrames(response *backend.QueryDataResponse, refIDs []string) (*data.Frame, error) {
+	var union *data.Frame
+	first := refIDs[0]
+
+	for _, refID := range refIDs {
+		res := response.Responses[refID]
+		if res.Error != nil {
+			return nil, fmt.Errorf("query %s failed: %w", refID, res.Error)
+		}
+		if len(res.Frames) == 0 {
+			continue
+		}
+		frame := res.Frames[0]
+
+		if union == nil {
+			union = frame.EmptyCopy()
+			first = refID
+			for i, field := range frame.Fields {
+				union.Fields[i].Config = field.Config
+			}
+			//The union keeps the meta of the first frame, with the notices of all of them.
+			if frame.Meta != nil {
+				meta := *frame.Meta
+				meta.Notices = nil
+				union.Meta = &meta
+			}
+		}
+		if frame.Meta != nil {
+			for _, notice := range frame.Meta.Notices {
+				addNotice(union, notice.Severity, notice.Text)
+			}
+		}
+
+		if len(frame.Fields) != len(union.Fields) {
+			return nil, fmt.Errorf("query %s returns %d columns, query %s returns %d", refID, len(frame.Fields), first, len(union.Fields))
+		}
+		for i, field := range frame.Fields {
+			if field.Name != union.Fields[i].Name || field.Type() != union.Fields[i].Type() {
+				return nil, fmt.Errorf("column %d of query %s is %s %s, query %s has %s %s", i+1, refID,
+					field.Name, field.Type().ItemTypeString(), first, union.Fields[i].Name, union.Fields[i].Type().ItemTypeString())
+			}
+		}
+
+		for row := 0; row < frame.Rows(); row++ {
+			union.AppendRow(frame.RowCopy(row)...)
+		}
+	}
+
+	if union == nil {
+		union = data.NewFrame("response")
+	}
+
+	return union, nil
+}
+
+// Field types that can be used as cast hints in the queryModel.
+const (
+	castTime    = "time"    // Epoch seconds.
+	castTimeMs  = "time_ms" // Epoch milliseconds.
+	castBool    = "bool"
+	castInt64   = "int64"
+	castFloat64 = "float64"
+	castString  = "string"
+)
+
+// applyFieldTypes converts the fields named in hints, matched case-insensitively, to the
+// hinted type. Nullable fields stay nullable.
+func applyFieldTypes(frame *data.Frame, hints map[string]string) error {
+	for column, target := range hints {
+		for i, field := range frame.Fields {
+			if !strings.EqualFold(field.Name, column) {
+				continue
+			}
+
+			converted, err := castField(field, target)
+			if err != nil {
+				return fmt.Errorf("column %s: %w", field.Name, err)
+			}
+			frame.Fields[i] = converted
+		}
+	}
+
+	return nil
+}
+
+func castField(field *data.Field, target string) (*data.Field, error) {
+	var fieldType data.FieldType
+	switch target {
+	case castTime, castTimeMs:
+		fieldType = data.FieldTypeTime
+	case castBool:
+		fieldType = data.FieldTypeBool
+	case castInt64:
+		fieldType = data.FieldTypeInt64
+	case castFloat64:
+		fieldType = data.FieldTypeFloat64
+	case castString:
+		fieldType = data.FieldTypeString
+	default:
+		return nil, fmt.Errorf("unknown field type %q", target)
+	}
+
+	source := field.Type()
+	numeric := source.Numeric()
+	text := source == data.FieldTypeString || source == data.FieldTypeNullableString
+
+	switch {
+	case target == castString:
+	case (target == castTime || target == castTimeMs) && !numeric:
+		return nil, fmt.Errorf("can't convert %s to %s", source.ItemTypeString(), target)
+	case !numeric && !text && source != data.FieldTypeBool && source != data.FieldTypeNullableBool:
+		return nil, fmt.Errorf("can't convert %s to %s", source.ItemTypeString(), target)
+	}
+
+	if field.Nullable() {
+		fieldType = fieldType.NullableType()
+	}
+
+	out := data.NewFieldFromFieldType(fieldType, field.Len())
+	out.Name = field.Name
+	out.Labels = field.Labels
+	out.Config = field.Config
+
+	for i := 0; i < field.Len(); i++ {
+		v, ok := field.ConcreteAt(i)
+		if !ok {
+			continue
+		}
+
+		converted, err := castValue(field, i, v, target)
+		if err != nil {
+			return nil, err
+		}
+		out.SetConcrete(i, converted)
+	}
+
+	return out, nil
+}
+
+func castValue(field *data.Field, i int, v interface{}, target string) (interface{}, error) {
+	if target == castString {
+		return fmt.Sprint(v), nil
+	}
+
+	if s, ok := v.(string); ok {
+		s = strings.TrimSpace(s)
+		switch target {
+		case castBool:
+			return strconv.ParseBool(s)
+		case castInt64:
+			return strconv.ParseInt(s, 10, 64)
+		default:
+			return strconv.ParseFloat(s, 64)
+		}
+	}
+
+	f, err := field.FloatAt(i)
+	if err != nil {
+		return nil, err
+	}
+
+	switch target {
+	case castTime:
+		return time.Unix(int64(f), 0).UTC(), nil
+	case castTimeMs:
+		return time.Unix(0, int64(f)*int64(time.Millisecond)).UTC(), nil
+	case castBool:
+		return f != 0, nil
+	case castInt64:
+		return int64(f), nil
+	default:
+		return f, nil
+	}
+}
+
+// splitFrame groups the rows of the frame by the value of the named field, and returns a
+// frame per group in the order the values first occur. The frames are named after their
+// value, which is also set as a label on their fields, and don't hold the split field.
+// Each frame gets a copy of the frame's meta.
+func splitFrame(frame *data.Frame, fieldName string) ([]*data.Frame, error) {
+	splitIdx := -1
+	for i, field := range frame.Fields {
+		if strings.EqualFold(field.Name, fieldName) {
+			splitIdx = i
+			break
+		}
+	}
+	if splitIdx < 0 {
+		return nil, fmt.Errorf("split column %s not found in the result", fieldName)
+	}
+
+	if frame.Rows() == 0 {
+		return []*data.Frame{frame}, nil
+	}
+
+	splitField := frame.Fields[splitIdx]
+
+	var keys []string
+	groups := make(map[string]*data.Frame)
+	for row := 0; row < frame.Rows(); row++ {
+		key := "null"
+		if v, ok := splitField.ConcreteAt(row); ok {
+			key = fmt.Sprint(v)
+		}
+
+		group, ok := groups[key]
+		if !ok {
+			group = data.NewFrame(key)
+			group.Meta = copyMeta(frame.Meta)
+			for i, field := range frame.Fields {
+				if i == splitIdx {
+					continue
+				}
+				groupField := data.NewFieldFromFieldType(field.Type(), 0)
+				groupField.Name = field.Name
+				groupField.Config = field.Config
+				if field.Type() != data.FieldTypeTime && field.Type() != data.FieldTypeNullableTime {
+					groupField.Labels = data.Labels{splitField.Name: key}
+				}
+				group.Fields = append(group.Fields, groupField)
+			}
+			groups[key] = group
+			keys = append(keys, key)
+		}
+
+		values := frame.RowCopy(row)
+		group.AppendRow(append(values[:splitIdx:splitIdx], values[splitIdx+1:]...)...)
+	}
+
+	frames := make([]*data.Frame, len(keys))
+	for i, key := range keys {
+		frames[i] = groups[key]
+	}
+	return frames, nil
+}
+
+// filterRows keeps the rows of the frame whose value in the named field compares to
+// value with op. Rows where the field is null are dropped. The meta and field config of
+// the frame are kept.
+func filterRows(frame *data.Frame, fieldName, op string, value float64) (*data.Frame, error) {
+	var compare func(v float64) bool
+	switch op {
+	case ">":
+		compare = func(v float64) bool { return v > value }
+	case ">=":
+		compare = func(v float64) bool { return v >= value }
+	case "<":
+		compare = func(v float64) bool { return v < value }
+	case "<=":
+		compare = func(v float64) bool { return v <= value }
+	case "=", "==":
+		compare = func(v float64) bool { return v == value }
+	case "!=", "<>":
+		compare = func(v float64) bool { return v != value }
+	default:
+		return nil, fmt.Errorf("unknown filter operator %q", op)
+	}
+
+	fieldIdx := -1
+	for i, field := range frame.Fields {
+		if strings.EqualFold(field.Name, fieldName) {
+			fieldIdx = i
+			break
+		}
+	}
+	if fieldIdx < 0 {
+		return nil, fmt.Errorf("filter field %s not found in the result", fieldName)
+	}
+
+	field := frame.Fields[fieldIdx]
+	if !field.Type().Numeric() {
+		return nil, fmt.Errorf("filter field %s is not numeric", fieldName)
+	}
+
+	filtered := frame.EmptyCopy()
+	filtered.Meta = frame.Meta
+	for i, field := range frame.Fields {
+		filtered.Fields[i].Config = field.Config
+	}
+	for row := 0; row < frame.Rows(); row++ {
+		v, err := field.FloatAt(row)
+		if err != nil || math.IsNaN(v) || !compare(v) {
+			continue
+		}
+		filtered.AppendRow(frame.RowCopy(row)...)
+	}
+
+	return filtered, nil
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func intPtr(i int) *int { return &i }
+
+func float64Ptr(f float64) *float64 { return &f }
+
+func TestColumnDecimalPlaces(t *testing.T) {
+	tests := []struct {
+		name      string
+		column    string
+		global    *int
+		perColumn map[string]int
+		want      int
+		wantOK    bool
+	}{
+		{name: "not configured", column: "PRICE"},
+		{name: "global", column: "PRICE", global: intPtr(2), want: 2, wantOK: true},
+		{name: "per column", column: "PRICE", perColumn: map[string]int{"PRICE": 1}, want: 1, wantOK: true},
+		{name: "per column in lower case", column: "PRICE", perColumn: map[string]int{"price": 1}, want: 1, wantOK: true},
+		{name: "per column before global", column: "PRICE", global: intPtr(2), perColumn: map[string]int{"PRICE": 0}, want: 0, wantOK: true},
+		{name: "other column falls back to global", column: "QTY", global: intPtr(2), perColumn: map[string]int{"PRICE": 0}, want: 2, wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := columnDecimalPlaces(tt.column, tt.global, tt.perColumn)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("columnDecimalPlaces() = %d, %v, want %d, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRoundFloatFields(t *testing.T) {
+	tests := []struct {
+		name      string
+		global    *int
+		perColumn map[string]int
+		wantPrice float64
+		wantRatio float64
+	}{
+		{name: "not configured", wantPrice: 1.23456, wantRatio: 0.98765},
+		{name: "global", global: intPtr(2), wantPrice: 1.23, wantRatio: 0.99},
+		{name: "per column", perColumn: map[string]int{"price": 1}, wantPrice: 1.2, wantRatio: 0.98765},
+		{name: "zero places", global: intPtr(0), wantPrice: 1, wantRatio: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := data.NewFrame("",
+				data.NewField("PRICE", nil, []float64{1.23456}),
+				data.NewField("RATIO", nil, []*float64{float64Ptr(0.98765)}),
+				data.NewField("QTY", nil, []int64{3}),
+			)
+			roundFloatFields(frame, tt.global, tt.perColumn)
+
+			if got := frame.Fields[0].At(0).(float64); got != tt.wantPrice {
+				t.Errorf("PRICE = %v, want %v", got, tt.wantPrice)
+			}
+			if got := frame.Fields[1].At(0).(*float64); *got != tt.wantRatio {
+				t.Errorf("RATIO = %v, want %v", *got, tt.wantRatio)
+			}
+			if got := frame.Fields[2].At(0).(int64); got != 3 {
+				t.Errorf("QTY = %v, want 3", got)
+			}
+		})
+	}
+}
+
+func TestApplyUnits(t *testing.T) {
+	tests := []struct {
+		name         string
+		field        string
+		unit         string
+		unitBySuffix map[string]string
+		want         string
+	}{
+		{name: "no suffixes", field: "SIZE_BYTES"},
+		{name: "matching suffix", field: "SIZE_BYTES", unitBySuffix: map[string]string{"_bytes": "bytes"}, want: "bytes"},
+		{name: "longest suffix wins", field: "READ_MS", unitBySuffix: map[string]string{"s": "s", "_ms": "ms"}, want: "ms"},
+		{name: "no match", field: "ROWS", unitBySuffix: map[string]string{"_bytes": "bytes"}},
+		{name: "unit already set", field: "SIZE_BYTES", unit: "decbytes", unitBySuffix: map[string]string{"_bytes": "bytes"}, want: "decbytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field := data.NewField(tt.field, nil, []int64{1})
+			if tt.unit != "" {
+				field.Config = &data.FieldConfig{Unit: tt.unit}
+			}
+			applyUnits(data.NewFrame("", field), tt.unitBySuffix)
+
+			got := ""
+			if field.Config != nil {
+				got = field.Config.Unit
+			}
+			if got != tt.want {
+				t.Errorf("unit = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCastField(t *testing.T) {
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		name    string
+		field   *data.Field
+		target  string
+		want    interface{} // The value of the first row.
+		wantErr bool
+	}{
+		{name: "epoch seconds to time", field: data.NewField("T", nil, []int64{1614600000}), target: castTime, want: time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{name: "epoch milliseconds to time", field: data.NewField("T", nil, []float64{1614600000500}), target: castTimeMs, want: time.Date(2021, 3, 1, 12, 0, 0, 500000000, time.UTC)},
+		{name: "string to time", field: data.NewField("T", nil, []string{"1614600000"}), target: castTime, wantErr: true},
+		{name: "int to bool", field: data.NewField("B", nil, []int32{2}), target: castBool, want: true},
+		{name: "string to bool", field: data.NewField("B", nil, []string{" true "}), target: castBool, want: true},
+		{name: "string to int64", field: data.NewField("N", nil, []string{"42"}), target: castInt64, want: int64(42)},
+		{name: "bad string to int64", field: data.NewField("N", nil, []string{"forty-two"}), target: castInt64, wantErr: true},
+		{name: "float to int64", field: data.NewField("N", nil, []float64{4.9}), target: castInt64, want: int64(4)},
+		{name: "nullable string to float64", field: data.NewField("N", nil, []*string{str("1.5")}), target: castFloat64, want: float64Ptr(1.5)},
+		{name: "bool to float64", field: data.NewField("N", nil, []bool{true}), target: castFloat64, want: float64(1)},
+		{name: "int to string", field: data.NewField("S", nil, []int64{7}), target: castString, want: "7"},
+		{name: "time to int64", field: data.NewField("N", nil, []time.Time{{}}), target: castInt64, wantErr: true},
+		{name: "unknown type", field: data.NewField("N", nil, []int64{1}), target: "decimal", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := castField(tt.field, tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("castField() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Name != tt.field.Name {
+				t.Errorf("castField() name = %q, want %q", got.Name, tt.field.Name)
+			}
+			if got.Nullable() != tt.field.Nullable() {
+				t.Errorf("castField() nullable = %v, want %v", got.Nullable(), tt.field.Nullable())
+			}
+			if v := got.At(0); !reflect.DeepEqual(v, tt.want) {
+				t.Errorf("castField() = %v (%T), want %v (%T)", v, v, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyFieldTypes(t *testing.T) {
+	frame := data.NewFrame("", data.NewField("CREATED", nil, []int64{1614600000}), data.NewField("N", nil, []string{"1"}))
+	if err := applyFieldTypes(frame, map[string]string{"created": castTime}); err != nil {
+		t.Fatalf("applyFieldTypes() error = %v", err)
+	}
+	if frame.Fields[0].Type() != data.FieldTypeTime || frame.Fields[1].Type() != data.FieldTypeString {
+		t.Errorf("applyFieldTypes() types = %s, %s", frame.Fields[0].Type(), frame.Fields[1].Type())
+	}
+
+	if err := applyFieldTypes(frame, map[string]string{"N": castTime}); err == nil {
+		t.Errorf("applyFieldTypes() converted a string to a time")
+	}
+}
+
+func TestSplitFrame(t *testing.T) {
+	t0 := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	host := func(s string) *string { return &s }
+	frame := data.NewFrame("",
+		data.NewField("TIME", nil, []time.Time{t0, t0, t0.Add(time.Minute), t0.Add(time.Minute)}),
+		data.NewField("HOST", nil, []*string{host("b"), host("a"), host("b"), nil}),
+		data.NewField("CPU", nil, []float64{1, 2, 3, 4}),
+	)
+
+	tests := []struct {
+		name      string
+		frame     *data.Frame
+		field     string
+		wantNames []string
+		wantRows  []int
+		wantErr   bool
+	}{
+		{name: "groups in order of occurrence", frame: frame, field: "host", wantNames: []string{"b", "a", "null"}, wantRows: []int{2, 1, 1}},
+		{name: "empty frame", frame: frame.EmptyCopy(), field: "HOST", wantNames: []string{""}, wantRows: []int{0}},
+		{name: "unknown field", frame: frame, field: "REGION", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, err := splitFrame(tt.frame, tt.field)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitFrame() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(frames) != len(tt.wantNames) {
+				t.Fatalf("splitFrame() returned %d frames, want %d", len(frames), len(tt.wantNames))
+			}
+			for i, f := range frames {
+				if f.Name != tt.wantNames[i] || f.Rows() != tt.wantRows[i] {
+					t.Errorf("frame %d = %q with %d rows, want %q with %d rows", i, f.Name, f.Rows(), tt.wantNames[i], tt.wantRows[i])
+				}
+				if f.Rows() == 0 {
+					continue
+				}
+				if len(f.Fields) != 2 {
+					t.Fatalf("frame %d has %d fields, want 2", i, len(f.Fields))
+				}
+				if f.Fields[0].Labels != nil {
+					t.Errorf("frame %d time field labels = %v, want none", i, f.Fields[0].Labels)
+				}
+				if got := f.Fields[1].Labels["HOST"]; got != tt.wantNames[i] {
+					t.Errorf("frame %d label HOST = %q, want %q", i, got, tt.wantNames[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSplitFrameMeta(t *testing.T) {
+	frame := data.NewFrame("",
+		data.NewField("HOST", nil, []string{"a", "b"}),
+		data.NewField("CPU", nil, []float64{1, 2}),
+	)
+	addNotice(frame, data.NoticeSeverityWarning, "skipped 1 row")
+	setCustomMeta(frame, "source", "scan")
+
+	frames, err := splitFrame(frame, "HOST")
+	if err != nil {
+		t.Fatalf("splitFrame() error = %v", err)
+	}
+	for i, f := range frames {
+		if f.Meta == nil || len(f.Meta.Notices) != 1 || f.Meta.Notices[0].Text != "skipped 1 row" {
+			t.Errorf("frame %d meta = %+v, want the notices of the frame", i, f.Meta)
+		}
+	}
+
+	//The frames are shaped on their own, their meta can't be shared.
+	addNotice(frames[0], data.NoticeSeverityInfo, "first only")
+	setCustomMeta(frames[0], "stats", "first only")
+	if len(frames[1].Meta.Notices) != 1 || len(frame.Meta.Notices) != 1 {
+		t.Errorf("a notice on one frame was added to the others")
+	}
+	if _, ok := frames[1].Meta.Custom.(map[string]interface{})["stats"]; ok {
+		t.Errorf("custom meta of one frame was set on the others")
+	}
+}
+
+func TestFilterRows(t *testing.T) {
+	cpu := func(f float64) *float64 { return &f }
+	frame := data.NewFrame("",
+		data.NewField("HOST", nil, []string{"a", "b", "c", "d"}),
+		data.NewField("CPU", nil, []*float64{cpu(10), cpu(50), nil, cpu(90)}),
+	)
+
+	tests := []struct {
+		name      string
+		field     string
+		op        string
+		value     float64
+		wantHosts []string
+		wantErr   bool
+	}{
+		{name: "greater", field: "cpu", op: ">", value: 50, wantHosts: []string{"d"}},
+		{name: "greater or equal", field: "CPU", op: ">=", value: 50, wantHosts: []string{"b", "d"}},
+		{name: "less", field: "CPU", op: "<", value: 50, wantHosts: []string{"a"}},
+		{name: "less or equal", field: "CPU", op: "<=", value: 50, wantHosts: []string{"a", "b"}},
+		{name: "equal", field: "CPU", op: "=", value: 50, wantHosts: []string{"b"}},
+		{name: "not equal", field: "CPU", op: "<>", value: 50, wantHosts: []string{"a", "d"}},
+		{name: "unknown operator", field: "CPU", op: "~", wantErr: true},
+		{name: "unknown field", field: "MEM", op: ">", wantErr: true},
+		{name: "not numeric", field: "HOST", op: ">", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filtered, err := filterRows(frame, tt.field, tt.op, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("filterRows() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var hosts []string
+			for i := 0; i < filtered.Rows(); i++ {
+				hosts = append(hosts, filtered.Fields[0].At(i).(string))
+			}
+			if !reflect.DeepEqual(hosts, tt.wantHosts) {
+				t.Errorf("filterRows() hosts = %v, want %v", hosts, tt.wantHosts)
+			}
+		})
+	}
+}
+
+func TestUnionFrames(t *testing.T) {
+	frame := func(name string, values ...int64) data.Frames {
+		return data.Frames{data.NewFrame("", data.NewField(name, nil, values))}
+	}
+
+	tests := []struct {
+		name      string
+		responses map[string]backend.DataResponse
+		wantRows  int
+		wantErr   bool
+	}{
+		{
+			name: "appends the rows",
+			responses: map[string]backend.DataResponse{
+				"A": {Frames: frame("N", 1, 2)},
+				"B": {Frames: frame("N", 3)},
+			},
+			wantRows: 3,
+		},
+		{
+			name: "skips queries without frames",
+			responses: map[string]backend.DataResponse{
+				"A": {},
+				"B": {Frames: frame("N", 3)},
+			},
+			wantRows: 1,
+		},
+		{
+			name:      "no frames at all",
+			responses: map[string]backend.DataResponse{"A": {}, "B": {}},
+		},
+		{
+			name: "different columns",
+			responses: map[string]backend.DataResponse{
+				"A": {Frames: frame("N", 1)},
+				"B": {Frames: frame("M", 1)},
+			},
+			wantErr: true,
+		},
+		{
+			name: "failed query",
+			responses: map[string]backend.DataResponse{
+				"A": {Frames: frame("N", 1)},
+				"B": {Error: errors.New("SQL0204N")},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := &backend.QueryDataResponse{Responses: tt.responses}
+			union, err := unionFrames(response, []string{"A", "B"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unionFrames() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && union.Rows() != tt.wantRows {
+				t.Errorf("unionFrames() rows = %d, want %d", union.Rows(), tt.wantRows)
+			}
+		})
+	}
+}
+
+func TestUnionFramesMeta(t *testing.T) {
+	frameA := data.NewFrame("", data.NewField("N", nil, []int64{1}))
+	frameA.Meta = &data.FrameMeta{ExecutedQueryString: "SELECT N FROM A", PreferredVisualization: data.VisTypeTable}
+	setCustomMeta(frameA, "execution", executionMeta{Rows: 1})
+	addNotice(frameA, data.NoticeSeverityWarning, "skipped 1 row")
+	frameB := data.NewFrame("", data.NewField("N", nil, []int64{2}))
+	addNotice(frameB, data.NoticeSeverityWarning, "cut off at 1 row")
+
+	response := &backend.QueryDataResponse{Responses: map[string]backend.DataResponse{
+		"A": {Frames: data.Frames{frameA}},
+		"B": {Frames: data.Frames{frameB}},
+	}}
+	union, err := unionFrames(response, []string{"A", "B"})
+	if err != nil {
+		t.Fatalf("unionFrames() error = %v", err)
+	}
+
+	if union.Meta == nil {
+		t.Fatal("unionFrames() dropped the meta")
+	}
+	if union.Meta.ExecutedQueryString != "SELECT N FROM A" || union.Meta.PreferredVisualization != data.VisTypeTable {
+		t.Errorf("unionFrames() meta = %+v, want the meta of the first frame", union.Meta)
+	}
+	if _, ok := union.Meta.Custom.(map[string]interface{})["execution"]; !ok {
+		t.Errorf("unionFrames() dropped the execution meta")
+	}
+	var notices []string
+	for _, notice := range union.Meta.Notices {
+		notices = append(notices, notice.Text)
+	}
+	if want := []string{"skipped 1 row", "cut off at 1 row"}; !reflect.DeepEqual(notices, want) {
+		t.Errorf("unionFrames() notices = %v, want %v", notices, want)
+	}
+	if len(frameA.Meta.Notices) != 1 {
+		t.Errorf("unionFrames() changed the notices of the first frame")
+	}
+}
+
+func TestFilterRowsMeta(t *testing.T) {
+	decimals := uint16(1)
+	frame := data.NewFrame("", data.NewField("CPU", nil, []float64{10, 90}))
+	frame.Fields[0].Config = &data.FieldConfig{Unit: "percent", Decimals: &decimals}
+	frame.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeTable}
+	addNotice(frame, data.NoticeSeverityWarning, "skipped 1 row")
+
+	filtered, err := filterRows(frame, "CPU", ">", 50)
+	if err != nil {
+		t.Fatalf("filterRows() error = %v", err)
+	}
+	if filtered.Meta == nil || filtered.Meta.PreferredVisualization != data.VisTypeTable || len(filtered.Meta.Notices) != 1 {
+		t.Errorf("filterRows() meta = %+v, want the meta of the frame", filtered.Meta)
+	}
+	if config := filtered.Fields[0].Config; config == nil || config.Unit != "percent" {
+		t.Errorf("filterRows() field config = %+v, want the config of the field", config)
+	}
+}
@@ -1,19 +1,54 @@
-import { DataSourceInstanceSettings } from '@grafana/data';
+import { AnnotationQuery, DataSourceInstanceSettings, ScopedVars } from '@grafana/data';
 import { DataSourceWithBackend } from '@grafana/runtime';
 import { MyDataSourceOptions, MyQuery } from './types';
 import { getTemplateSrv } from '@grafana/runtime';
 
+// Quotes a value as a Db2 string literal, doubling embedded quotes.
+function quoteLiteral(value: any): string {
+  return "'" + String(value).replace(/'/g, "''") + "'";
+}
+
+// Grafana's interval variables, left in the query for the backend's $__interval and
+// $__interval_ms macros, which write them as Db2 durations.
+const backendMacroVariables = ['__interval', '__interval_ms'];
+
+// Formats a variable value for use in SQL. A multi-value variable becomes a comma-separated
+// list of literals, to be used as IN ($var); a single value becomes a single literal.
+// Grafana's built-in variables, like $__range_s, are numbers or durations and aren't quoted.
+export function interpolateVariable(value: string | string[], variable?: { name?: string }): string {
+  if (variable?.name?.startsWith('__')) {
+    return String(value);
+  }
+  if (Array.isArray(value)) {
+    return value.map(quoteLiteral).join(',');
+  }
+  return quoteLiteral(value);
+}
+
 export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptions> {
   constructor(instanceSettings: DataSourceInstanceSettings<MyDataSourceOptions>) {
     super(instanceSettings);
+
+    //Annotation queries return their rows as events, see the annotations format.
+    this.annotations = {
+      prepareQuery(anno: AnnotationQuery<MyQuery>) {
+        return anno.target ? { ...anno.target, format: 'annotations' } : undefined;
+      },
+    };
   }
 
-  applyTemplateVariables(query: MyQuery) {
+  applyTemplateVariables(query: MyQuery, scopedVars: ScopedVars) {
     const templateSrv = getTemplateSrv();
 
+    //Without their scoped values, Grafana leaves the interval variables as they are.
+    const vars: ScopedVars = { ...scopedVars };
+    for (const name of backendMacroVariables) {
+      delete vars[name];
+    }
+
     return {
       ...query,
-      queryText: query.queryText ? templateSrv.replace(query.queryText) : '',
+      queryText: query.queryText ? templateSrv.replace(query.queryText, vars, interpolateVariable) : '',
     };
   }
 }
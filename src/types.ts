@@ -1,7 +1,58 @@
 import { DataQuery, DataSourceJsonData } from '@grafana/data';
 
+export interface ColumnConfig {
+  unit?: string;
+  decimals?: number;
+  displayName?: string;
+}
+
 export interface MyQuery extends DataQuery {
   queryText?: string;
+  format?: 'time_series' | 'table' | 'long' | 'annotations' | 'logs';
+  fillMode?: 'none' | 'null' | 'previous' | 'linear' | 'zero';
+  errorFrame?: boolean;
+  trimChar?: boolean;
+  emptyStringAsNull?: boolean;
+  decimalAsString?: boolean;
+  maxTextLength?: number;
+  binaryEncoding?: 'base64' | 'hex';
+  timeColumn?: string;
+  timeOfDayColumn?: string;
+  timeColumnType?: 'epoch_s' | 'epoch_ms';
+  skipBadTimeRows?: boolean;
+  partialOnError?: boolean;
+  boolColumns?: string[];
+  truthyValues?: string[];
+  clientUserId?: string;
+  workloadClass?: string;
+  fieldTypes?: { [column: string]: 'time' | 'time_ms' | 'bool' | 'int64' | 'float64' | 'string' };
+  fieldConfig?: { [column: string]: ColumnConfig };
+  unionGroup?: string;
+  splitColumn?: string;
+  params?: Array<string | number | boolean | null>;
+  filterField?: string;
+  filterOp?: '>' | '>=' | '<' | '<=' | '=' | '!=';
+  filterValue?: number;
+  decimalPlaces?: number;
+  columnDecimalPlaces?: { [column: string]: number };
+  computeStats?: boolean;
+  schemaFingerprint?: boolean;
+  exec?: boolean;
+  builder?: QueryBuilder;
+}
+
+/**
+ * Structured (no-SQL) query, turned into SQL by the backend
+ */
+export interface QueryBuilder {
+  schema?: string;
+  table: string;
+  columns?: string[];
+  aggregates?: Array<{ function: 'COUNT' | 'SUM' | 'AVG' | 'MIN' | 'MAX'; column: string; alias?: string }>;
+  timeColumn?: string;
+  groupBy?: string[];
+  orderBy?: Array<{ column: string; direction?: 'ASC' | 'DESC' }>;
+  limit?: number;
 }
 
 export const defaultQuery: Partial<MyQuery> = {
@@ -16,6 +67,56 @@ export interface MyDataSourceOptions extends DataSourceJsonData {
   port?: string;
   database?: string;
   user?: string;
+  healthCheckQuery?: string;
+  healthCheckTimeout?: number;
+  deepHealthCheck?: boolean;
+  deepHealthCheckQuery?: string;
+  healthChecks?: HealthCheck[];
+  poolWindows?: PoolWindow[];
+  poolSize?: number;
+  connMaxLifetime?: number;
+  maxIdleConns?: number;
+  busyTimeout?: number;
+  queryTimeout?: number;
+  connectTimeout?: number;
+  maxRetries?: number;
+  retryBackoff?: number;
+  allowExec?: boolean;
+  readOnly?: boolean;
+  fieldNameCase?: 'preserve' | 'lower' | 'upper';
+  autoLimit?: number;
+  maxRows?: number;
+  unitBySuffix?: { [suffix: string]: string };
+  statementConcentrator?: boolean;
+  fetchSize?: number;
+  authentication?: 'password' | 'kerberos';
+  ssl?: boolean;
+  sslServerCertificate?: string;
+  schema?: string;
+  currentPath?: string;
+  connectionString?: string;
+  sharedPool?: boolean;
+  catalogCacheTTL?: number;
+  secondaryHost?: string;
+  secondaryPort?: string;
+}
+
+/**
+ * A time-of-day window ("HH:MM") with its own concurrency limit
+ */
+export interface PoolWindow {
+  start: string;
+  end: string;
+  limit: number;
+}
+
+/**
+ * A named probe query run by the health check, failing it when critical
+ */
+export interface HealthCheck {
+  name: string;
+  query: string;
+  critical?: boolean;
 }
 
 /**